@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/atsu/goat/stream"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// stdoutConsumer is a stream.StreamConsumer that writes every message to an io.Writer
+type stdoutConsumer struct {
+	opts  *options
+	out   *bufio.Writer
+	count int
+	err   error
+
+	sc      *stream.StreamConfig
+	targets map[int32]kafka.Offset // first wanted offset per partition, when starting at an offset or time
+	doneCh  chan bool
+}
+
+var _ stream.StreamConsumer = &stdoutConsumer{}
+
+func newStdoutConsumer(w io.Writer, opts *options) *stdoutConsumer {
+	return &stdoutConsumer{
+		opts:    opts,
+		out:     bufio.NewWriter(w),
+		targets: make(map[int32]kafka.Offset),
+		doneCh:  make(chan bool)}
+}
+
+func (c *stdoutConsumer) Start(sc *stream.StreamConfig, _ interface{}) error {
+	c.sc = sc
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		close(c.doneCh)
+	}()
+	return nil
+}
+
+func (c *stdoutConsumer) Message(m *kafka.Message) error {
+	c.err = c.write(m)
+	return c.err
+}
+
+func (c *stdoutConsumer) write(m *kafka.Message) error {
+	if skip, err := c.position(m); err != nil || skip {
+		return err
+	}
+
+	b, err := formatMessage(c.opts.format, m)
+	if err != nil {
+		return err
+	}
+	if _, err := c.out.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	c.count++
+	return nil
+}
+
+// position seeks a partition to the requested start the first time it is seen,
+// and returns true for any message that precedes the start and should be skipped.
+func (c *stdoutConsumer) position(m *kafka.Message) (bool, error) {
+	if c.opts.startOffset < 0 && c.opts.start.IsZero() {
+		return false, nil
+	}
+
+	tp := m.TopicPartition
+	target, ok := c.targets[tp.Partition]
+	if !ok {
+		var err error
+		if target, err = c.startOffset(tp); err != nil {
+			return true, err
+		}
+		c.targets[tp.Partition] = target
+		if target != tp.Offset {
+			tp.Offset = target
+			return true, c.sc.GetConsumer().Seek(tp, stream.SessionTimeoutDefault)
+		}
+	}
+
+	// messages fetched before the seek may still be in flight
+	return target >= 0 && m.TopicPartition.Offset < target, nil
+}
+
+func (c *stdoutConsumer) startOffset(tp kafka.TopicPartition) (kafka.Offset, error) {
+	if c.opts.startOffset >= 0 {
+		return kafka.Offset(c.opts.startOffset), nil
+	}
+
+	tp.Offset = kafka.Offset(c.opts.start.UnixNano() / int64(time.Millisecond))
+	offsets, err := c.sc.GetConsumer().OffsetsForTimes([]kafka.TopicPartition{tp}, stream.SessionTimeoutDefault)
+	if err != nil {
+		return kafka.OffsetInvalid, err
+	}
+	if len(offsets) != 1 {
+		return kafka.OffsetInvalid, fmt.Errorf("no offset found for partition %d", tp.Partition)
+	}
+	return offsets[0].Offset, nil
+}
+
+func (c *stdoutConsumer) Interval(time.Time) error {
+	return c.out.Flush()
+}
+
+func (c *stdoutConsumer) Timeout(_ time.Time, stalled bool) bool {
+	return stalled
+}
+
+func (c *stdoutConsumer) Error(e kafka.Error) bool {
+	fmt.Fprintln(os.Stderr, "kafka error:", e)
+	return e.Code() == kafka.ErrAllBrokersDown
+}
+
+func (c *stdoutConsumer) Process() (bool, error) {
+	if c.opts.max > 0 && c.count >= c.opts.max {
+		return true, nil
+	}
+	return false, nil
+}
+
+func (c *stdoutConsumer) Finish() error {
+	if err := c.out.Flush(); err != nil {
+		return err
+	}
+	return c.err
+}
+
+func (c *stdoutConsumer) DoneCh() <-chan bool {
+	return c.doneCh
+}
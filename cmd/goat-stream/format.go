@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/atsu/goat/util"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+const (
+	formatRaw  = "raw"  // message value as is
+	formatJson = "json" // message value as pretty printed json
+	formatMeta = "meta" // one json object per message including topic, partition, offset, etc.
+)
+
+// metaMessage is the formatMeta representation of a kafka.Message
+type metaMessage struct {
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Timestamp time.Time         `json:"timestamp"`
+	Key       string            `json:"key,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Value     json.RawMessage   `json:"value"`
+}
+
+func formatMessage(format string, m *kafka.Message) ([]byte, error) {
+	switch format {
+	case formatRaw:
+		return m.Value, nil
+	case formatJson:
+		return util.JsonPrettyPrint(m.Value), nil
+	case formatMeta:
+		return json.Marshal(newMetaMessage(m))
+	default:
+		return nil, fmt.Errorf("unknown format: %q", format)
+	}
+}
+
+func newMetaMessage(m *kafka.Message) metaMessage {
+	mm := metaMessage{
+		Partition: m.TopicPartition.Partition,
+		Offset:    int64(m.TopicPartition.Offset),
+		Timestamp: m.Timestamp,
+		Key:       string(m.Key),
+		Value:     m.Value,
+	}
+	if m.TopicPartition.Topic != nil {
+		mm.Topic = *m.TopicPartition.Topic
+	}
+	if len(m.Headers) > 0 {
+		mm.Headers = make(map[string]string, len(m.Headers))
+		for _, h := range m.Headers {
+			mm.Headers[h.Key] = string(h.Value)
+		}
+	}
+	// non-json values are embedded as a json string
+	if !json.Valid(m.Value) {
+		mm.Value, _ = json.Marshal(string(m.Value))
+	}
+	return mm
+}
+
+// parseStartTime accepts either an RFC3339 time or unix milliseconds
+func parseStartTime(s string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid start time %q: must be RFC3339 or unix milliseconds", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/atsu/goat/util"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+func testMessage(value string) *kafka.Message {
+	topic := "atsu.test"
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 3, Offset: 42},
+		Value:          []byte(value),
+		Key:            []byte("key"),
+		Timestamp:      time.Unix(1559761560, 0).UTC(),
+		Headers:        []kafka.Header{{Key: "h", Value: []byte("v")}},
+	}
+}
+
+func TestFormatMessage(t *testing.T) {
+	value := `{"state":"green","msg":"a-ok"}`
+
+	raw, err := formatMessage(formatRaw, testMessage(value))
+	assert.NoError(t, err)
+	assert.Equal(t, value, string(raw))
+
+	pretty, err := formatMessage(formatJson, testMessage(value))
+	assert.NoError(t, err)
+	assert.Equal(t, util.JsonPrettyPrint([]byte(value)), pretty)
+
+	_, err = formatMessage("bogus", testMessage(value))
+	assert.Error(t, err)
+}
+
+func TestFormatMessageMeta(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"json", `{"a":1}`, `{"a":1}`},
+		{"text", `not json`, `"not json"`},
+		{"empty", ``, `""`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := formatMessage(formatMeta, testMessage(test.value))
+			assert.NoError(t, err)
+
+			var got map[string]interface{}
+			assert.NoError(t, json.Unmarshal(b, &got))
+			assert.Equal(t, "atsu.test", got["topic"])
+			assert.Equal(t, float64(3), got["partition"])
+			assert.Equal(t, float64(42), got["offset"])
+			assert.Equal(t, "key", got["key"])
+			assert.Equal(t, map[string]interface{}{"h": "v"}, got["headers"])
+
+			value, _ := json.Marshal(got["value"])
+			assert.JSONEq(t, test.want, string(value))
+		})
+	}
+}
+
+func TestParseStartTime(t *testing.T) {
+	want := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	got, err := parseStartTime("2020-06-01T00:00:00Z")
+	assert.NoError(t, err)
+	assert.True(t, want.Equal(got))
+
+	got, err = parseStartTime("1590969600000")
+	assert.NoError(t, err)
+	assert.True(t, want.Equal(got))
+
+	_, err = parseStartTime("yesterday")
+	assert.Error(t, err)
+}
+
+func TestOptionsValidate(t *testing.T) {
+	assert.NoError(t, (&options{format: formatRaw, startOffset: -1}).validate())
+	assert.Error(t, (&options{format: "xml", startOffset: -1}).validate())
+	assert.Error(t, (&options{format: formatMeta, startOffset: 10, startTime: "1590969600000"}).validate())
+
+	o := &options{format: formatJson, startOffset: -1, startTime: "1590969600000"}
+	assert.NoError(t, o.validate())
+	assert.False(t, o.start.IsZero())
+}
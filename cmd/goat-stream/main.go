@@ -0,0 +1,105 @@
+// goat-stream is a small debugging tool for producing to and consuming from goat streams.
+// It honors the same flags and ATSU_ environment configuration as stream.StreamConfig,
+// so topics resolve to `<prefix>.<topic>` exactly as they do in our services.
+//
+//	goat-stream -topic health.gather consume
+//	goat-stream -topic health.gather -format json -max 10 consume
+//	goat-stream -topic health.gather -start-time 2020-06-01T00:00:00Z -format meta consume
+//	cat events.ndjson | goat-stream -topic scratch produce
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/atsu/goat/stream"
+)
+
+// flushInterval bounds how long consumed messages may sit in the stdout buffer
+const flushInterval = time.Second
+
+const usage = `usage: goat-stream [flags] consume|produce
+
+flags:
+`
+
+func main() {
+	sc := &stream.StreamConfig{}
+	sc.SetFlags()
+
+	opts := &options{}
+	opts.SetFlags()
+
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(sc, opts, flag.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, "goat-stream:", err)
+		os.Exit(1)
+	}
+}
+
+func run(sc *stream.StreamConfig, opts *options, mode string) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	switch mode {
+	case "consume":
+		sc.Timeout = opts.timeout
+		sc.Interval = flushInterval
+		return sc.Consume(newStdoutConsumer(os.Stdout, opts), nil)
+	case "produce":
+		n, err := produceLines(sc, os.Stdin, opts.timeout)
+		fmt.Fprintf(os.Stderr, "produced %d message(s) to %s\n", n, sc.FullTopic(""))
+		return err
+	default:
+		flag.Usage()
+		return fmt.Errorf("unknown mode: %q", mode)
+	}
+}
+
+// options are the goat-stream specific flags, StreamConfig covers the rest
+type options struct {
+	format      string
+	max         int
+	startOffset int64
+	startTime   string
+	timeout     time.Duration
+
+	start time.Time // parsed startTime
+}
+
+// SetFlags to install the goat-stream command-line flag(s)
+func (o *options) SetFlags() {
+	flag.StringVar(&o.format, "format", formatRaw, "Output format (raw, json, meta).")
+	flag.IntVar(&o.max, "max", 0, "Stop after this many messages (0 is unlimited).")
+	flag.Int64Var(&o.startOffset, "start-offset", -1, "Start consuming each partition at this offset.")
+	flag.StringVar(&o.startTime, "start-time", "", "Start consuming at this time (RFC3339 or unix milliseconds).")
+	flag.DurationVar(&o.timeout, "timeout", 0, "Stop consuming when no message arrives within this duration (0 never stops). "+
+		"When producing, the longest wait for messages to be delivered (0 is 30s).")
+}
+
+func (o *options) validate() error {
+	switch o.format {
+	case formatRaw, formatJson, formatMeta:
+	default:
+		return fmt.Errorf("unknown format: %q", o.format)
+	}
+	if o.startTime != "" {
+		if o.startOffset >= 0 {
+			return fmt.Errorf("-start-offset and -start-time are mutually exclusive")
+		}
+		t, err := parseStartTime(o.startTime)
+		if err != nil {
+			return err
+		}
+		o.start = t
+	}
+	return nil
+}
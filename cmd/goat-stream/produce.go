@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/atsu/goat/stream"
+)
+
+// maxLineBytes is the longest stdin line that can be produced as a single message
+const maxLineBytes = 1024 * 1024
+
+// defaultDeliveryTimeout bounds the wait for produced messages to be delivered when -timeout is not set
+const defaultDeliveryTimeout = time.Second * 30
+
+// produceLines produces each line read from r as a message to the configured topic,
+// and returns the number of messages produced. It waits up to timeout (defaultDeliveryTimeout if 0)
+// for them to be delivered, returning an error with the number of undelivered messages if they aren't.
+func produceLines(sc stream.KafkaStreamConfig, r io.Reader, timeout time.Duration) (int, error) {
+	if _, err := sc.NewProducer(nil); err != nil {
+		return 0, err
+	}
+	defer sc.Close()
+
+	topic := sc.FullTopic("")
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+
+	n := 0
+	for scanner.Scan() {
+		// the scanner reuses its buffer, so each value needs its own copy
+		value := append([]byte(nil), scanner.Bytes()...)
+		if err := sc.Produce(&topic, value); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	if timeout <= 0 {
+		timeout = defaultDeliveryTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for remaining := sc.Flush(stream.DefaultFlushInterval); remaining > 0; remaining = sc.Flush(stream.DefaultFlushInterval) {
+		if time.Now().After(deadline) {
+			return n, fmt.Errorf("%d message(s) not delivered within %s", remaining, timeout)
+		}
+	}
+	return n, scanner.Err()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atsu/goat/stream"
+	streammocks "github.com/atsu/goat/stream/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func produceMock(undelivered int) *streammocks.KafkaStreamConfig {
+	scMock := streammocks.NewProducingStreamConfig("atsu", nil)
+	scMock.On("Flush", stream.DefaultFlushInterval).Return(undelivered)
+	return scMock
+}
+
+func TestProduceLines(t *testing.T) {
+	scMock := produceMock(0)
+	n, err := produceLines(scMock, strings.NewReader("a\nb\n"), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	scMock.AssertCalled(t, "Produce", mock.AnythingOfType("*string"), []byte("b"))
+
+	// messages that are never delivered don't block forever
+	scMock = produceMock(2)
+	start := time.Now()
+	n, err = produceLines(scMock, strings.NewReader("a\nb\n"), time.Millisecond*20)
+	assert.EqualError(t, err, "2 message(s) not delivered within 20ms")
+	assert.Equal(t, 2, n)
+	assert.True(t, time.Since(start) < time.Second)
+}
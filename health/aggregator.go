@@ -0,0 +1,284 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/atsu/goat/stream"
+	"github.com/atsu/goat/util"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// DefaultStaleAfter is how long an instance may go without reporting before it is stale
+const DefaultStaleAfter = time.Minute * 3
+
+// HealthTopic is the topic every reporter produces to, unless overridden with SetTopic
+const HealthTopic = "health"
+
+// Fleet groupings, see Aggregator.Group
+const (
+	GroupByService = "service"
+	GroupByState   = "state"
+	GroupByVersion = "version"
+)
+
+// Instance is the latest event of a service on a host
+type Instance struct {
+	Event
+	Topic    string `json:"topic"`
+	Received int64  `json:"received"` // unix timestamp
+	Stale    bool   `json:"stale"`
+	Gaps     uint64 `json:"gaps,omitempty"` // events missed according to Event.Seq
+}
+
+// InstanceRef identifies an instance in a FleetSummary
+type InstanceRef struct {
+	Service  string `json:"service"`
+	Hostname string `json:"hostname"`
+	State    State  `json:"state"`
+	Message  string `json:"msg"`
+	Received int64  `json:"received"` // unix timestamp
+}
+
+// FleetSummary counts the instances by state, and lists those that need attention
+type FleetSummary struct {
+	Total  int           `json:"total"`
+	States map[State]int `json:"states"` // stale instances are counted by their last state
+	Red    []InstanceRef `json:"red"`
+	Yellow []InstanceRef `json:"yellow"`
+	Stale  []InstanceRef `json:"stale"`
+}
+
+type instanceKey struct {
+	service  string
+	hostname string
+}
+
+// Aggregator keeps the latest health event of every service instance, it is a stream.StreamConsumer
+// for the health topics, see Consume.
+type Aggregator struct {
+	staleAfter time.Duration
+	now        func() time.Time
+
+	mux       sync.RWMutex
+	instances map[instanceKey]*Instance
+	invalid   uint64 // messages that are not health events
+	rejected  uint64 // events failing verification
+	verifier  *Verifier
+
+	doneOnce sync.Once
+	doneCh   chan bool
+}
+
+var _ stream.StreamConsumer = &Aggregator{}
+
+// NewAggregator creates an aggregator marking instances stale after staleAfter, DefaultStaleAfter if < 1
+func NewAggregator(staleAfter time.Duration) *Aggregator {
+	if staleAfter <= 0 {
+		staleAfter = DefaultStaleAfter
+	}
+	return &Aggregator{
+		staleAfter: staleAfter,
+		now:        time.Now,
+		instances:  make(map[instanceKey]*Instance),
+		doneCh:     make(chan bool),
+	}
+}
+
+// Consume consumes every health topic until Stop is called. The topic defaults to HealthTopic, and
+// is always globbed so that `<prefix>.health.<service>` topics are included.
+func (a *Aggregator) Consume(sc *stream.StreamConfig) error {
+	if sc.Topic == "" || sc.Topic == "unset" {
+		sc.Topic = HealthTopic
+	}
+	sc.Glob = true
+	return sc.Consume(a, nil)
+}
+
+// Stop ends Consume
+func (a *Aggregator) Stop() {
+	a.doneOnce.Do(func() { close(a.doneCh) })
+}
+
+// Observe records an event, keeping it if it is the latest for its service and host
+func (a *Aggregator) Observe(e Event, topic string) {
+	if e.Name == EventHost { // host snapshots don't carry the service state
+		return
+	}
+	key := instanceKey{service: e.Service, hostname: e.Hostname}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	inst := &Instance{Event: e, Topic: topic, Received: a.now().Unix()}
+	if cur, ok := a.instances[key]; ok {
+		if cur.Timestamp > e.Timestamp {
+			return // out of order
+		}
+		inst.Gaps = cur.Gaps
+		if cur.Seq > 0 && e.Seq > cur.Seq+1 { // a lower seq is a restart
+			inst.Gaps += e.Seq - cur.Seq - 1
+		}
+	}
+	a.instances[key] = inst
+}
+
+// Forget removes an instance, e.g. one that was decommissioned
+func (a *Aggregator) Forget(service, hostname string) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	delete(a.instances, instanceKey{service: service, hostname: hostname})
+}
+
+// SetVerifier only accepts consumed events signed with a key of v, nil accepts every event
+func (a *Aggregator) SetVerifier(v *Verifier) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.verifier = v
+}
+
+// Rejected returns the number of consumed messages that failed verification, see SetVerifier
+func (a *Aggregator) Rejected() uint64 {
+	return atomic.LoadUint64(&a.rejected)
+}
+
+// Invalid returns the number of consumed messages that were not health events
+func (a *Aggregator) Invalid() uint64 {
+	return atomic.LoadUint64(&a.invalid)
+}
+
+// Instances returns every instance, sorted by service and hostname
+func (a *Aggregator) Instances() []Instance {
+	now := a.now()
+	a.mux.RLock()
+	out := make([]Instance, 0, len(a.instances))
+	for _, inst := range a.instances {
+		i := *inst
+		i.Stale = now.Sub(time.Unix(i.Received, 0)) > a.staleAfter
+		out = append(out, i)
+	}
+	a.mux.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Service != out[j].Service {
+			return out[i].Service < out[j].Service
+		}
+		return out[i].Hostname < out[j].Hostname
+	})
+	return out
+}
+
+// Group returns the instances grouped by GroupByService, GroupByState or GroupByVersion
+func (a *Aggregator) Group(by string) (map[string][]Instance, error) {
+	var key func(Instance) string
+	switch by {
+	case GroupByService, "":
+		key = func(i Instance) string { return i.Service }
+	case GroupByState:
+		key = func(i Instance) string { return i.State.String() }
+	case GroupByVersion:
+		key = func(i Instance) string { return fmt.Sprintf("%s %s", i.Service, i.Version) }
+	default:
+		return nil, fmt.Errorf("unknown grouping: %s", by)
+	}
+
+	out := make(map[string][]Instance)
+	for _, i := range a.Instances() {
+		out[key(i)] = append(out[key(i)], i)
+	}
+	return out, nil
+}
+
+// Summary counts the instances by state and lists the Red, Yellow and stale ones
+func (a *Aggregator) Summary() FleetSummary {
+	s := FleetSummary{
+		States: make(map[State]int),
+		Red:    []InstanceRef{},
+		Yellow: []InstanceRef{},
+		Stale:  []InstanceRef{},
+	}
+	for _, i := range a.Instances() {
+		s.Total++
+		s.States[i.State]++
+		ref := InstanceRef{Service: i.Service, Hostname: i.Hostname, State: i.State, Message: i.Message, Received: i.Received}
+		switch {
+		case i.Stale:
+			s.Stale = append(s.Stale, ref)
+		case i.State == Red:
+			s.Red = append(s.Red, ref)
+		case i.State == Yellow:
+			s.Yellow = append(s.Yellow, ref)
+		}
+	}
+	return s
+}
+
+// FleetHandler returns the instances grouped by the `by` query parameter (service, state or version)
+func (a *Aggregator) FleetHandler(w http.ResponseWriter, req *http.Request) {
+	groups, err := a.Group(req.URL.Query().Get("by"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(util.MarshalWithPretty(req, groups))
+}
+
+// SummaryHandler returns the FleetSummary
+func (a *Aggregator) SummaryHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(util.MarshalWithPretty(req, a.Summary()))
+}
+
+func (a *Aggregator) Start(*stream.StreamConfig, interface{}) error { return nil }
+
+func (a *Aggregator) Message(m *kafka.Message) error {
+	a.mux.RLock()
+	v := a.verifier
+	a.mux.RUnlock()
+
+	var e Event
+	if v != nil {
+		var err error
+		if e, err = v.Verify(m.Value); err != nil {
+			atomic.AddUint64(&a.rejected, 1)
+			return nil
+		}
+	} else if err := json.Unmarshal(m.Value, &e); err != nil {
+		atomic.AddUint64(&a.invalid, 1)
+		return nil
+	}
+	if e.Type != EventType || e.Service == "" {
+		atomic.AddUint64(&a.invalid, 1)
+		return nil
+	}
+	topic := ""
+	if m.TopicPartition.Topic != nil {
+		topic = *m.TopicPartition.Topic
+	}
+	a.Observe(e, topic)
+	return nil
+}
+
+func (a *Aggregator) Interval(time.Time) error { return nil }
+
+func (a *Aggregator) Timeout(time.Time, bool) bool { return false }
+
+// Error stops the aggregator on fatal errors only
+func (a *Aggregator) Error(e kafka.Error) bool {
+	if e.IsFatal() {
+		fmt.Printf("[warn] aggregator stopping on fatal kafka error: %v\n", e)
+		return true
+	}
+	return false
+}
+
+func (a *Aggregator) Process() (bool, error) { return false, nil }
+
+func (a *Aggregator) Finish() error { return nil }
+
+func (a *Aggregator) DoneCh() <-chan bool { return a.doneCh }
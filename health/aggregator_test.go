@@ -0,0 +1,119 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+func healthMessage(t *testing.T, e Event) *kafka.Message {
+	e.Type = EventType
+	b, err := json.Marshal(e)
+	assert.NoError(t, err)
+	topic := "atsu.health." + e.Service
+	return &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}, Value: b}
+}
+
+func TestAggregator(t *testing.T) {
+	now := time.Unix(10000, 0)
+	a := NewAggregator(time.Minute)
+	a.now = func() time.Time { return now }
+
+	assert.NoError(t, a.Message(healthMessage(t, Event{Service: "api", Hostname: "h1", Version: "v1", State: Green, Timestamp: 1})))
+	assert.NoError(t, a.Message(healthMessage(t, Event{Service: "api", Hostname: "h2", Version: "v2", State: Red, Message: "db down", Timestamp: 1})))
+	now = now.Add(time.Second * 30)
+	assert.NoError(t, a.Message(healthMessage(t, Event{Service: "db", Hostname: "h1", Version: "v1", State: Yellow, Timestamp: 1})))
+
+	// the latest event wins, older and host events are ignored
+	assert.NoError(t, a.Message(healthMessage(t, Event{Service: "api", Hostname: "h1", Version: "v1", State: Yellow, Timestamp: 3})))
+	assert.NoError(t, a.Message(healthMessage(t, Event{Service: "api", Hostname: "h1", Version: "v1", State: Red, Timestamp: 2})))
+	assert.NoError(t, a.Message(healthMessage(t, Event{Service: "api", Hostname: "h1", Name: EventHost, State: Red, Timestamp: 4})))
+
+	// junk is counted
+	assert.NoError(t, a.Message(&kafka.Message{Value: []byte("nope")}))
+	assert.NoError(t, a.Message(&kafka.Message{Value: []byte(`{"etype":"other","service":"x"}`)}))
+	assert.Equal(t, uint64(2), a.Invalid())
+
+	instances := a.Instances()
+	assert.Len(t, instances, 3)
+	assert.Equal(t, "api", instances[0].Service)
+	assert.Equal(t, "h1", instances[0].Hostname)
+	assert.Equal(t, Yellow, instances[0].State)
+	assert.Equal(t, "atsu.health.api", instances[0].Topic)
+
+	// h2 last reported 31s ago, then 61s ago
+	now = now.Add(time.Second * 31)
+	s := a.Summary()
+	assert.Equal(t, 3, s.Total)
+	assert.Equal(t, map[State]int{Yellow: 2, Red: 1}, s.States)
+	assert.Len(t, s.Red, 0)
+	assert.Len(t, s.Stale, 1)
+	assert.Equal(t, "h2", s.Stale[0].Hostname)
+	assert.Len(t, s.Yellow, 2)
+
+	groups, err := a.Group(GroupByState)
+	assert.NoError(t, err)
+	assert.Len(t, groups["yellow"], 2)
+	assert.Len(t, groups["red"], 1)
+	groups, err = a.Group(GroupByVersion)
+	assert.NoError(t, err)
+	assert.Len(t, groups["api v1"], 1)
+	assert.Len(t, groups["api v2"], 1)
+	_, err = a.Group("color")
+	assert.Error(t, err)
+
+	a.Forget("api", "h2")
+	assert.Len(t, a.Instances(), 2)
+}
+
+func TestAggregatorGaps(t *testing.T) {
+	a := NewAggregator(time.Minute)
+	for _, seq := range []uint64{1, 2, 5, 6, 1, 3} { // a gap of 2, a restart, then a gap of 1
+		a.Observe(Event{Service: "api", Hostname: "h1", State: Green, Seq: seq}, "health.api")
+	}
+	instances := a.Instances()
+	assert.Len(t, instances, 1)
+	assert.Equal(t, uint64(3), instances[0].Gaps)
+	assert.Equal(t, uint64(3), instances[0].Seq)
+}
+
+func TestAggregatorHandlers(t *testing.T) {
+	a := NewAggregator(0)
+	assert.Equal(t, DefaultStaleAfter, a.staleAfter)
+	a.Observe(Event{Service: "api", Hostname: "h1", State: Red, Message: "down"}, "")
+
+	rec := httptest.NewRecorder()
+	a.FleetHandler(rec, httptest.NewRequest("GET", "/fleet", nil))
+	var groups map[string][]Instance
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &groups))
+	assert.Equal(t, Red, groups["api"][0].State)
+
+	rec = httptest.NewRecorder()
+	a.FleetHandler(rec, httptest.NewRequest("GET", "/fleet?by=nope", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	a.SummaryHandler(rec, httptest.NewRequest("GET", "/summary", nil))
+	var s FleetSummary
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &s))
+	assert.Equal(t, "down", s.Red[0].Message)
+	assert.Empty(t, s.Stale)
+}
+
+func TestAggregatorStreamConsumer(t *testing.T) {
+	a := NewAggregator(0)
+	assert.False(t, a.Error(kafka.NewError(kafka.ErrPartitionEOF, "eof", false)))
+	assert.False(t, a.Timeout(time.Now(), true))
+	a.Stop()
+	a.Stop()
+	select {
+	case <-a.DoneCh():
+	default:
+		t.Fatal("done channel not closed")
+	}
+}
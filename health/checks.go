@@ -0,0 +1,245 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultCheckInterval = time.Second * 30
+const DefaultCheckTimeout = time.Second * 10
+
+// ChecksDataKey is the Event.Data key holding the per check results
+const ChecksDataKey = "checks"
+
+// severity orders the states from best to worst
+var severity = map[State]int{Blue: 0, Green: 1, Yellow: 2, Red: 3, Gray: 4}
+
+// Worse returns true if s is worse than other, in the Blue, Green, Yellow, Red, Gray ordering.
+// Unknown states are treated as the worst.
+func (s State) Worse(other State) bool {
+	return s.severity() > other.severity()
+}
+
+func (s State) severity() int {
+	if v, ok := severity[s]; ok {
+		return v
+	}
+	return len(severity)
+}
+
+// WorstState returns the worst of the given states, or StateDefault if none are given
+func WorstState(states ...State) State {
+	worst := StateDefault
+	for _, s := range states {
+		if s.Worse(worst) {
+			worst = s
+		}
+	}
+	return worst
+}
+
+// CheckResult is returned by a CheckFunc
+type CheckResult struct {
+	State   State
+	Message string
+}
+
+// CheckFunc is a named health check, it should return promptly once ctx is done
+type CheckFunc func(ctx context.Context) CheckResult
+
+// CheckOptions controls how a check is run, zero values mean the defaults
+type CheckOptions struct {
+	Interval time.Duration // time between runs, DefaultCheckInterval if unset
+	Timeout  time.Duration // a run taking longer is Red, DefaultCheckTimeout if unset
+	Critical bool          // only critical checks contribute to the overall State
+}
+
+// CheckStatus is the latest result of a check as reported in Event.Data
+type CheckStatus struct {
+	State    State  `json:"state"`
+	Message  string `json:"msg"`
+	Critical bool   `json:"critical"`
+	LastRun  int64  `json:"last_run"`    // unix timestamp, 0 if never run
+	Duration int64  `json:"duration_ms"` // duration of the last run
+}
+
+type healthCheck struct {
+	name   string
+	fn     CheckFunc
+	opts   CheckOptions
+	stopCh chan struct{}
+
+	mux    sync.Mutex
+	status CheckStatus
+	loopCh chan struct{} // closed to stop the current loop when it is restarted
+}
+
+// RegisterCheck registers (or replaces) a named check, which runs immediately and then every opts.Interval
+// until it is unregistered or the reporter is stopped.
+func (r *Reporter) RegisterCheck(name string, fn CheckFunc, opts CheckOptions) {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultCheckInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultCheckTimeout
+	}
+	c := &healthCheck{
+		name:   name,
+		fn:     fn,
+		opts:   opts,
+		stopCh: make(chan struct{}),
+		status: CheckStatus{State: StateDefault, Critical: opts.Critical},
+	}
+
+	r.checkMux.Lock()
+	defer r.checkMux.Unlock()
+	if old, ok := r.checks.Load(name); ok {
+		close(old.(*healthCheck).stopCh)
+	}
+	r.checks.Store(name, c)
+
+	r.startCheck(c)
+}
+
+// UnregisterCheck stops and removes a named check
+func (r *Reporter) UnregisterCheck(name string) {
+	r.checkMux.Lock()
+	defer r.checkMux.Unlock()
+	if c, ok := r.checks.Load(name); ok {
+		r.checks.Delete(name)
+		close(c.(*healthCheck).stopCh)
+	}
+}
+
+// RunChecks runs every registered check now and waits for them to finish
+func (r *Reporter) RunChecks() {
+	var wg sync.WaitGroup
+	r.checks.Range(func(_, c interface{}) bool {
+		wg.Add(1)
+		go func(c *healthCheck) {
+			defer wg.Done()
+			c.run()
+		}(c.(*healthCheck))
+		return true
+	})
+	wg.Wait()
+}
+
+// CheckStatuses returns the latest status of every registered check
+func (r *Reporter) CheckStatuses() map[string]CheckStatus {
+	out := make(map[string]CheckStatus)
+	r.checks.Range(func(k, c interface{}) bool {
+		out[k.(string)] = c.(*healthCheck).latest()
+		return true
+	})
+	return out
+}
+
+// startCheck starts the loop of a check within the current lifecycle, stopping any previous loop
+func (r *Reporter) startCheck(c *healthCheck) {
+	r.run.mux.Lock()
+	defer r.run.mux.Unlock()
+	done, wg := r.lifecycle()
+
+	loop := make(chan struct{})
+	c.mux.Lock()
+	if c.loopCh != nil {
+		close(c.loopCh)
+	}
+	c.loopCh = loop
+	c.mux.Unlock()
+
+	if wg != nil {
+		wg.Add(1)
+	}
+	go func() {
+		if wg != nil {
+			defer wg.Done()
+		}
+		r.runCheckLoop(c, done, loop)
+	}()
+}
+
+func (r *Reporter) runCheckLoop(c *healthCheck, done, loop <-chan struct{}) {
+	ticker := time.NewTicker(c.opts.Interval)
+	defer ticker.Stop()
+
+	c.run()
+	for {
+		select {
+		case <-done:
+			return
+		case <-loop:
+			return
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			c.run()
+		}
+	}
+}
+
+func (c *healthCheck) run() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	resultCh := make(chan CheckResult, 1) // buffered, so a late check doesn't leak
+	go func() {
+		resultCh <- c.fn(ctx)
+	}()
+
+	var result CheckResult
+	select {
+	case result = <-resultCh:
+	case <-ctx.Done():
+		result = CheckResult{State: Red, Message: fmt.Sprintf("timed out after %s", c.opts.Timeout)}
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.status.State = result.State
+	c.status.Message = result.Message
+	c.status.LastRun = start.Unix()
+	c.status.Duration = int64(time.Since(start) / time.Millisecond)
+}
+
+func (c *healthCheck) latest() CheckStatus {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.status
+}
+
+// aggregateChecks returns the worst of state and all critical checks, along with the message to report.
+// When a check is worse than state its message is used, prefixed with the check name.
+func aggregateChecks(state State, message string, statuses map[string]CheckStatus) (State, string) {
+	names := make([]string, 0, len(statuses))
+	for name := range statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names) // for a stable message when checks tie
+
+	var failing []string
+	worst := state
+	for _, name := range names {
+		s := statuses[name]
+		if !s.Critical || s.LastRun == 0 {
+			continue
+		}
+		if s.State.Worse(worst) {
+			worst = s.State
+			failing = failing[:0]
+		}
+		if s.State == worst && worst != state {
+			failing = append(failing, fmt.Sprintf("%s: %s", name, s.Message))
+		}
+	}
+	if worst == state {
+		return state, message
+	}
+	return worst, strings.Join(failing, "; ")
+}
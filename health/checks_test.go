@@ -0,0 +1,148 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateOrdering(t *testing.T) {
+	ordered := []State{Blue, Green, Yellow, Red, Gray}
+	for i := 1; i < len(ordered); i++ {
+		assert.True(t, ordered[i].Worse(ordered[i-1]))
+		assert.False(t, ordered[i-1].Worse(ordered[i]))
+	}
+	assert.True(t, State("purple").Worse(Gray))
+
+	assert.Equal(t, StateDefault, WorstState())
+	assert.Equal(t, Red, WorstState(Green, Red, Yellow))
+	assert.Equal(t, Green, WorstState(Blue, Green))
+}
+
+func staticCheck(state State, msg string) CheckFunc {
+	return func(context.Context) CheckResult {
+		return CheckResult{State: state, Message: msg}
+	}
+}
+
+func TestChecksAggregate(t *testing.T) {
+	r := NewReporter("test", "test", "test", func(error) {})
+	r.SetHealth(Green, "a-ok")
+
+	r.RegisterCheck("db", staticCheck(Green, "connected"), CheckOptions{Critical: true})
+	r.RegisterCheck("cache", staticCheck(Red, "cold"), CheckOptions{}) // not critical
+	r.RunChecks()
+
+	h := r.Health()
+	assert.Equal(t, Green, h.State)
+	assert.Equal(t, "a-ok", h.Message)
+
+	statuses := h.Data.(map[string]interface{})[ChecksDataKey].(map[string]CheckStatus)
+	assert.Len(t, statuses, 2)
+	assert.Equal(t, Red, statuses["cache"].State)
+	assert.Equal(t, "cold", statuses["cache"].Message)
+	assert.False(t, statuses["cache"].Critical)
+	assert.True(t, statuses["db"].Critical)
+	assert.NotZero(t, statuses["db"].LastRun)
+
+	// a failing critical check takes over the state and message
+	r.RegisterCheck("disk", staticCheck(Yellow, "80% full"), CheckOptions{Critical: true})
+	r.RegisterCheck("queue", staticCheck(Yellow, "backlog"), CheckOptions{Critical: true})
+	r.RunChecks()
+	h = r.Health()
+	assert.Equal(t, Yellow, h.State)
+	assert.Equal(t, "disk: 80% full; queue: backlog", h.Message)
+
+	// but the manual state still counts when it is worse
+	r.SetHealth(Red, "manual")
+	h = r.Health()
+	assert.Equal(t, Red, h.State)
+	assert.Equal(t, "manual", h.Message)
+	r.SetHealth(Green, "a-ok")
+
+	r.UnregisterCheck("disk")
+	r.UnregisterCheck("queue")
+	r.UnregisterCheck("nope")
+	assert.Equal(t, Green, r.Health().State)
+	assert.Len(t, r.CheckStatuses(), 2)
+
+	assert.NoError(t, r.Stop())
+}
+
+func TestCheckTimeout(t *testing.T) {
+	r := NewReporter("test", "test", "test", func(error) {})
+	r.SetHealth(Green, "")
+
+	block := make(chan struct{})
+	defer close(block)
+	r.RegisterCheck("slow", func(ctx context.Context) CheckResult {
+		<-block
+		return CheckResult{State: Green}
+	}, CheckOptions{Timeout: 10 * time.Millisecond, Critical: true})
+	r.RunChecks()
+
+	h := r.Health()
+	assert.Equal(t, Red, h.State)
+	assert.Equal(t, "slow: timed out after 10ms", h.Message)
+
+	assert.NoError(t, r.Stop())
+}
+
+func TestCheckInterval(t *testing.T) {
+	r := NewReporter("test", "test", "test", func(error) {})
+
+	runs := make(chan struct{}, 10)
+	r.RegisterCheck("tick", func(ctx context.Context) CheckResult {
+		runs <- struct{}{}
+		return CheckResult{State: Green}
+	}, CheckOptions{Interval: 5 * time.Millisecond, Critical: true})
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-runs:
+		case <-time.After(time.Second):
+			t.Fatal("check did not run on its interval")
+		}
+	}
+	assert.Eventually(t, func() bool { return r.Health().State == Green }, time.Second, time.Millisecond)
+
+	// replacing a check stops the old one
+	r.RegisterCheck("tick", staticCheck(Yellow, "replaced"), CheckOptions{Critical: true})
+	r.RunChecks()
+	assert.Equal(t, Yellow, r.Health().State)
+
+	assert.NoError(t, r.Stop())
+}
+
+func TestAggregateChecksNeverRun(t *testing.T) {
+	statuses := map[string]CheckStatus{"new": {State: StateDefault, Critical: true}}
+	state, msg := aggregateChecks(Green, "ok", statuses)
+	assert.Equal(t, Green, state)
+	assert.Equal(t, "ok", msg)
+}
+
+func TestCheckConcurrentRegister(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			<-start
+			r.RegisterCheck("db", staticCheck(Green, "ok"), CheckOptions{Interval: time.Hour})
+		}()
+		go func() {
+			defer wg.Done()
+			<-start
+			r.UnregisterCheck("db")
+		}()
+	}
+	close(start) // a check closed twice panics
+	wg.Wait()
+	assert.True(t, len(r.CheckStatuses()) <= 1)
+	assert.NoError(t, r.Stop())
+}
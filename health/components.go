@@ -0,0 +1,254 @@
+package health
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ComponentsDataKey is the Event.Data key holding the health of every component
+const ComponentsDataKey = "components"
+
+// CompositePolicy decides how the states of components affect the state of their parent
+type CompositePolicy string
+
+const (
+	CompositeWorst    = CompositePolicy("worst")    // the worst component, the default
+	CompositeMajority = CompositePolicy("majority") // the worst state more than half of the components are in, or worse
+	CompositeCritical = CompositePolicy("critical") // the worst component, but non-critical ones degrade to yellow at most
+	CompositeNone     = CompositePolicy("none")     // components are reported but don't affect the parent
+)
+
+// ComponentHealth is the health of a component as reported in Event.Data
+type ComponentHealth struct {
+	State      State                      `json:"state"` // including the state of its components
+	Message    string                     `json:"msg"`
+	Critical   bool                       `json:"critical,omitempty"`
+	Stats      map[string]interface{}     `json:"stats,omitempty"`
+	Components map[string]ComponentHealth `json:"components,omitempty"`
+}
+
+// Component is a named subsystem of a reporter, with its own state, message, stats and components.
+// The parent state is computed from its components with its CompositePolicy.
+type Component struct {
+	name     string
+	mux      sync.Mutex
+	state    State
+	message  string
+	critical bool
+	stats    map[string]interface{}
+	children components
+}
+
+// components are the children of a reporter or component
+type components struct {
+	mux      sync.Mutex
+	policy   CompositePolicy
+	children map[string]*Component
+}
+
+// Component returns the named component of the reporter, creating it if needed
+func (r *Reporter) Component(name string) *Component {
+	return r.components.get(name)
+}
+
+// RemoveComponent removes a named component, returning false if it didn't exist
+func (r *Reporter) RemoveComponent(name string) bool {
+	return r.components.remove(name)
+}
+
+// Components returns the health of every component
+func (r *Reporter) Components() map[string]ComponentHealth {
+	healths, _ := r.components.health()
+	return healths
+}
+
+// SetCompositePolicy sets how the state of the components affects the reporter state, CompositeWorst by default
+func (r *Reporter) SetCompositePolicy(p CompositePolicy) error {
+	return r.components.setPolicy(p)
+}
+
+// Name returns the component name
+func (c *Component) Name() string {
+	return c.name
+}
+
+// SetHealth sets the state and message of the component
+func (c *Component) SetHealth(state State, message string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.state = state
+	c.message = message
+}
+
+// SetCritical marks the component as critical, which only matters to a CompositeCritical parent
+func (c *Component) SetCritical(critical bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.critical = critical
+}
+
+// AddStat adds or replaces a stat of the component
+func (c *Component) AddStat(key string, val interface{}) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.stats[key] = val
+}
+
+// GetStat returns a stat of the component, nil if not found
+func (c *Component) GetStat(key string) interface{} {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.stats[key]
+}
+
+// ClearStat removes a stat of the component
+func (c *Component) ClearStat(key string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	delete(c.stats, key)
+}
+
+// ClearStats removes every stat of the component
+func (c *Component) ClearStats() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.stats = make(map[string]interface{})
+}
+
+// Component returns the named sub-component, creating it if needed
+func (c *Component) Component(name string) *Component {
+	return c.children.get(name)
+}
+
+// RemoveComponent removes a named sub-component, returning false if it didn't exist
+func (c *Component) RemoveComponent(name string) bool {
+	return c.children.remove(name)
+}
+
+// SetCompositePolicy sets how the state of the sub-components affects this component, CompositeWorst by default
+func (c *Component) SetCompositePolicy(p CompositePolicy) error {
+	return c.children.setPolicy(p)
+}
+
+// Health returns the health of the component, including its sub-components
+func (c *Component) Health() ComponentHealth {
+	children, policy := c.children.health()
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	h := ComponentHealth{Critical: c.critical, Components: children}
+	if len(c.stats) > 0 {
+		h.Stats = copyStats(c.stats)
+	}
+	h.State, h.Message = aggregateComponents(c.state, c.message, policy, children)
+	return h
+}
+
+func (cs *components) get(name string) *Component {
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+	if c, ok := cs.children[name]; ok {
+		return c
+	}
+	if cs.children == nil {
+		cs.children = make(map[string]*Component)
+	}
+	c := &Component{name: name, state: StateDefault, stats: make(map[string]interface{})}
+	cs.children[name] = c
+	return c
+}
+
+func (cs *components) remove(name string) bool {
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+	_, ok := cs.children[name]
+	delete(cs.children, name)
+	return ok
+}
+
+func (cs *components) setPolicy(p CompositePolicy) error {
+	switch p {
+	case CompositeWorst, CompositeMajority, CompositeCritical, CompositeNone:
+	default:
+		return fmt.Errorf("unknown composite policy %q", p)
+	}
+	cs.mux.Lock()
+	defer cs.mux.Unlock()
+	cs.policy = p
+	return nil
+}
+
+// health returns the health of every component, nil if there are none, along with the policy
+func (cs *components) health() (map[string]ComponentHealth, CompositePolicy) {
+	cs.mux.Lock()
+	children := make([]*Component, 0, len(cs.children))
+	for _, c := range cs.children {
+		children = append(children, c)
+	}
+	policy := cs.policy
+	cs.mux.Unlock()
+
+	if len(children) == 0 {
+		return nil, policy
+	}
+	out := make(map[string]ComponentHealth, len(children))
+	for _, c := range children {
+		out[c.name] = c.Health()
+	}
+	return out, policy
+}
+
+// aggregateComponents returns the state of a parent in state given its components, along with the message to report.
+// When the components are worse than state, the message names the components in the resulting state or worse.
+func aggregateComponents(state State, message string, policy CompositePolicy, healths map[string]ComponentHealth) (State, string) {
+	if policy == CompositeNone || len(healths) == 0 {
+		return state, message
+	}
+	names := make([]string, 0, len(healths))
+	for name := range healths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	effective := make(map[string]State, len(healths))
+	for _, name := range names {
+		s := healths[name].State
+		if policy == CompositeCritical && !healths[name].Critical && s.Worse(Yellow) {
+			s = Yellow
+		}
+		effective[name] = s
+	}
+
+	composite := StateDefault
+	if policy == CompositeMajority {
+		for _, candidate := range []State{Gray, Red, Yellow, Green} {
+			n := 0
+			for _, s := range effective {
+				if !candidate.Worse(s) {
+					n++
+				}
+			}
+			if n*2 > len(effective) {
+				composite = candidate
+				break
+			}
+		}
+	} else {
+		for _, s := range effective {
+			composite = WorstState(composite, s)
+		}
+	}
+	if !composite.Worse(state) {
+		return state, message
+	}
+
+	var failing []string
+	for _, name := range names {
+		if !composite.Worse(effective[name]) {
+			failing = append(failing, fmt.Sprintf("%s: %s", name, healths[name].Message))
+		}
+	}
+	return composite, strings.Join(failing, "; ")
+}
@@ -0,0 +1,89 @@
+package health
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReporterComponents(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	r.SetHealth(Green, "ok")
+	_, ok := r.Health().Data.(map[string]interface{})[ComponentsDataKey]
+	assert.False(t, ok)
+
+	ingest := r.Component("ingest")
+	assert.Equal(t, ingest, r.Component("ingest"))
+	ingest.SetHealth(Green, "consuming")
+	ingest.AddStat("lag", 12)
+	uploader := r.Component("uploader")
+	uploader.SetHealth(Green, "idle")
+
+	h := r.Health()
+	assert.Equal(t, Green, h.State)
+	assert.Equal(t, "ok", h.Message)
+	children := h.Data.(map[string]interface{})[ComponentsDataKey].(map[string]ComponentHealth)
+	assert.Len(t, children, 2)
+	assert.Equal(t, 12, children["ingest"].Stats["lag"])
+	assert.Nil(t, children["uploader"].Stats)
+
+	// nested components roll up
+	uploader.Component("s3").SetHealth(Red, "403 forbidden")
+	assert.Equal(t, Red, uploader.Health().State)
+	assert.Equal(t, "s3: 403 forbidden", uploader.Health().Message)
+	h = r.Health()
+	assert.Equal(t, Red, h.State)
+	assert.Equal(t, "uploader: s3: 403 forbidden", h.Message)
+
+	b, err := json.Marshal(h)
+	assert.NoError(t, err)
+	var decoded struct {
+		Data map[string]map[string]ComponentHealth `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, Red, decoded.Data[ComponentsDataKey]["uploader"].Components["s3"].State)
+
+	assert.NoError(t, r.SetCompositePolicy(CompositeNone))
+	assert.Equal(t, Green, r.Health().State)
+	assert.Error(t, r.SetCompositePolicy("best"))
+
+	assert.True(t, uploader.RemoveComponent("s3"))
+	assert.False(t, uploader.RemoveComponent("s3"))
+	assert.True(t, r.RemoveComponent("ingest"))
+	assert.Len(t, r.Components(), 1)
+}
+
+func TestAggregateComponents(t *testing.T) {
+	healths := map[string]ComponentHealth{
+		"a": {State: Red, Message: "down"},
+		"b": {State: Yellow, Message: "slow", Critical: true},
+		"c": {State: Green, Message: "ok"},
+	}
+	tests := []struct {
+		policy  CompositePolicy
+		state   State
+		message string
+	}{
+		{"", Red, "a: down"},
+		{CompositeWorst, Red, "a: down"},
+		{CompositeCritical, Yellow, "a: down; b: slow"},
+		{CompositeMajority, Yellow, "a: down; b: slow"},
+		{CompositeNone, Green, "fine"},
+	}
+	for _, test := range tests {
+		state, msg := aggregateComponents(Green, "fine", test.policy, healths)
+		assert.Equal(t, test.state, state, string(test.policy))
+		assert.Equal(t, test.message, msg, string(test.policy))
+	}
+
+	// a worse own state is kept
+	state, msg := aggregateComponents(Gray, "deploying", CompositeWorst, healths)
+	assert.Equal(t, Gray, state)
+	assert.Equal(t, "deploying", msg)
+
+	// a minority of failures doesn't degrade a majority parent
+	healths["b"] = ComponentHealth{State: Green}
+	state, _ = aggregateComponents(Green, "fine", CompositeMajority, healths)
+	assert.Equal(t, Green, state)
+}
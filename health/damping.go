@@ -0,0 +1,154 @@
+package health
+
+import (
+	"sync"
+	"time"
+)
+
+// DampingDataKey is the Event.Data key holding the DampingStatus when damping is enabled
+const DampingDataKey = "damping"
+
+// DampingPolicy delays reported state changes so that a flapping service reports a stable state.
+// A change is promoted once it has been seen on enough consecutive reports, or has persisted long enough,
+// whichever comes first. Zero values promote changes immediately, as do changes to or from Gray.
+type DampingPolicy struct {
+	WorsenReports  int           // consecutive reports a worse state must be seen on
+	WorsenAfter    time.Duration // time a worse state must persist
+	RecoverReports int           // consecutive reports a better state must be seen on
+	RecoverAfter   time.Duration // time a better state must persist
+
+	FlapWindow    time.Duration // window raw state changes are counted over
+	FlapThreshold int           // raw state changes within FlapWindow considered flapping, 0 disables detection
+}
+
+// DampingStatus is reported in Event.Data under DampingDataKey
+type DampingStatus struct {
+	RawState       State  `json:"raw_state"`
+	RawMessage     string `json:"raw_msg"`
+	Pending        State  `json:"pending,omitempty"` // state waiting to be promoted
+	PendingReports int    `json:"pending_reports,omitempty"`
+	Flapping       bool   `json:"flapping"`
+	Changes        int    `json:"changes"` // raw state changes within the flap window
+}
+
+type damper struct {
+	mux     sync.Mutex
+	policy  *DampingPolicy
+	started bool
+	state   State // promoted state
+	message string
+	raw     State
+
+	pending      State
+	pendingSince time.Time
+	pendingCount int
+	changes      []time.Time // raw state changes within the flap window
+}
+
+// SetDamping enables state damping, or disables it if p is nil.
+// Damping applies to reported events and to HealthHandler, the probes and MetricsHandler, not to Health.
+func (r *Reporter) SetDamping(p *DampingPolicy) {
+	d := &r.damping
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if p != nil {
+		cp := *p
+		p = &cp
+	}
+	d.policy, d.started, d.pending, d.changes = p, false, "", nil
+}
+
+func (d *damper) enabled() bool {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.policy != nil
+}
+
+// apply replaces the state and message of e with the damped ones
+func (d *damper) apply(e *Event, now time.Time) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.policy == nil {
+		return
+	}
+	p := d.policy
+
+	if !d.started { // nothing to damp against
+		d.started = true
+		d.state, d.message, d.raw = e.State, e.Message, e.State
+	}
+
+	if e.State != d.raw {
+		d.raw = e.State
+		d.changes = append(d.changes, now)
+	}
+	for len(d.changes) > 0 && now.Sub(d.changes[0]) > p.FlapWindow {
+		d.changes = d.changes[1:]
+	}
+
+	switch {
+	case e.State == d.state:
+		d.pending = ""
+		d.message = e.Message
+	default:
+		worse := e.State.Worse(d.state)
+		if d.pending == "" || d.pending.Worse(d.state) != worse {
+			// a new change, or one that reversed direction
+			d.pendingSince = now
+			d.pendingCount = 0
+		}
+		d.pending = e.State
+		d.pendingCount++
+
+		reports, after := p.RecoverReports, p.RecoverAfter
+		if worse {
+			reports, after = p.WorsenReports, p.WorsenAfter
+		}
+		maint := e.State == Gray || d.state == Gray // entering or leaving maintenance is never damped
+		if maint || (reports <= 1 && after <= 0) || (reports > 0 && d.pendingCount >= reports) ||
+			(after > 0 && now.Sub(d.pendingSince) >= after) {
+			d.state, d.message = e.State, e.Message
+			d.pending = ""
+		}
+	}
+
+	d.replace(e)
+}
+
+// peek replaces the state and message of e with the damped ones as of the last report, without counting
+// e as a report, so that on demand views show what was last reported
+func (d *damper) peek(e *Event) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.policy == nil || !d.started || e.State == Gray || d.state == Gray {
+		return // promoted as is on the next report
+	}
+	d.replace(e)
+}
+
+// replace sets the damped state and message on e, and the DampingStatus in its data. d.mux must be held.
+func (d *damper) replace(e *Event) {
+	p := d.policy
+	status := DampingStatus{
+		RawState:   e.State,
+		RawMessage: e.Message,
+		Flapping:   p.FlapThreshold > 0 && len(d.changes) >= p.FlapThreshold,
+		Changes:    len(d.changes),
+	}
+	if d.pending != "" {
+		status.Pending = d.pending
+		status.PendingReports = d.pendingCount
+	}
+	e.State, e.Message = d.state, d.message
+	if data, ok := e.Data.(map[string]interface{}); ok {
+		data[DampingDataKey] = status
+	}
+}
+
+// dampedHealth returns Health with the state and message damped as last reported,
+// as exposed by HealthHandler, the probes and MetricsHandler
+func (r *Reporter) dampedHealth() Event {
+	h := r.Health()
+	r.damping.peek(&h)
+	return h
+}
@@ -0,0 +1,97 @@
+package health
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDamperReports(t *testing.T) {
+	d := damper{policy: &DampingPolicy{WorsenReports: 2, RecoverReports: 3, FlapWindow: time.Minute, FlapThreshold: 3}}
+	now := time.Unix(1000, 0)
+	report := func(state State, msg string) Event {
+		now = now.Add(time.Second)
+		e := Event{State: state, Message: msg, Data: map[string]interface{}{}}
+		d.apply(&e, now)
+		return e
+	}
+
+	assert.Equal(t, Green, report(Green, "ok").State)
+
+	// a single bad report is damped
+	e := report(Yellow, "slow")
+	assert.Equal(t, Green, e.State)
+	assert.Equal(t, "ok", e.Message)
+	status := e.Data.(map[string]interface{})[DampingDataKey].(DampingStatus)
+	assert.Equal(t, DampingStatus{RawState: Yellow, RawMessage: "slow", Pending: Yellow, PendingReports: 1, Changes: 1}, status)
+
+	// bouncing back resets the pending change, and is detected as flapping
+	assert.Equal(t, Green, report(Green, "ok").State)
+	assert.Equal(t, Green, report(Yellow, "slow").State)
+	e = report(Red, "down")
+	assert.Equal(t, Red, e.State) // same direction, so the count carries over
+	assert.Equal(t, "down", e.Message)
+	assert.True(t, e.Data.(map[string]interface{})[DampingDataKey].(DampingStatus).Flapping)
+
+	// recovery needs its own confirmation
+	assert.Equal(t, Red, report(Green, "ok").State)
+	assert.Equal(t, Red, report(Green, "ok").State)
+	assert.Equal(t, Green, report(Green, "ok").State)
+
+	// flapping ends once the changes leave the window
+	now = now.Add(time.Minute)
+	assert.False(t, report(Green, "ok").Data.(map[string]interface{})[DampingDataKey].(DampingStatus).Flapping)
+}
+
+func TestDamperDuration(t *testing.T) {
+	d := damper{policy: &DampingPolicy{WorsenAfter: time.Second * 10}}
+	now := time.Unix(1000, 0)
+	report := func(state State, after time.Duration) State {
+		now = now.Add(after)
+		e := Event{State: state}
+		d.apply(&e, now)
+		return e.State
+	}
+
+	assert.Equal(t, Green, report(Green, 0))
+	assert.Equal(t, Green, report(Red, 0))
+	assert.Equal(t, Green, report(Red, time.Second*9))
+	assert.Equal(t, Red, report(Red, time.Second))
+	assert.Equal(t, Green, report(Green, time.Second)) // recovery is not damped
+}
+
+func TestReporterDamping(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	r.RemoveSink(KafkaSinkName)
+	var buf bytes.Buffer
+	r.AddSink(NewWriterSink("buf", &buf))
+	r.SetDamping(&DampingPolicy{WorsenReports: 3})
+
+	r.SetHealth(Green, "ok")
+	r.ReportHealth()
+	r.SetHealth(Yellow, "slow")
+	r.ReportHealth()
+
+	// on demand views show the state last reported, Health the raw one
+	assert.Equal(t, Green, r.dampedHealth().State)
+	assert.Equal(t, Green, r.Live().State)
+	assert.Contains(t, string(r.prometheusMetrics()), `state="green"} 1`)
+	assert.Equal(t, Yellow, r.Health().State)
+
+	r.SetHealth(Red, "bye")
+	r.report(EventShutdown) // never damped
+
+	events := sinkEvents(t, &buf)
+	assert.Equal(t, Green, events[1].State)
+	assert.Equal(t, Red, events[2].State)
+	assert.Len(t, r.Transitions(), 2)
+
+	// disabling damping reports the raw state
+	r.SetDamping(nil)
+	r.SetHealth(Yellow, "slow")
+	r.ReportHealth()
+	events = sinkEvents(t, &buf)
+	assert.Equal(t, Yellow, events[3].State)
+}
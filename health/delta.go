@@ -0,0 +1,111 @@
+package health
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// DefaultKeyframeInterval is the time between full snapshots in change-only reporting.
+// It is below DefaultStaleAfter, so that an Aggregator doesn't consider an unchanged instance stale.
+const DefaultKeyframeInterval = time.Minute * 2
+
+// DeltaPolicy enables change-only reporting. A report is only emitted when it is a keyframe, when its event is
+// not a plain status (e.g. startup or transition), or when the message or a watched stat changed since the
+// last emitted report. Keyframes are emitted on the first report once KeyframeInterval has passed since the
+// last one, so it should be a multiple of the report interval and below the staleness threshold of consumers.
+type DeltaPolicy struct {
+	KeyframeInterval time.Duration      // time between keyframes, DefaultKeyframeInterval if 0
+	Stats            map[string]float64 // watched stats, nested keys joined with '.', and the absolute change that is emitted
+}
+
+type deltaFilter struct {
+	mux      sync.Mutex
+	policy   *DeltaPolicy
+	seq      uint64
+	keyframe time.Time // time of the last keyframe
+	message  string
+	stats    map[string]interface{} // watched stats of the last emitted report
+	skipped  uint64
+}
+
+// SetDeltaReporting enables change-only reporting, nil disables it. Emitted events carry a sequence
+// number in Event.Seq, incremented for every emitted event, so consumers can detect gaps.
+func (r *Reporter) SetDeltaReporting(p *DeltaPolicy) {
+	d := &r.delta
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if p != nil {
+		cp := *p
+		if cp.KeyframeInterval <= 0 {
+			cp.KeyframeInterval = DefaultKeyframeInterval
+		}
+		p = &cp
+	}
+	d.policy, d.keyframe, d.message, d.stats = p, time.Time{}, "", nil
+}
+
+// DeltaSkipped returns the number of reports not emitted because nothing changed
+func (r *Reporter) DeltaSkipped() uint64 {
+	d := &r.delta
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.skipped
+}
+
+// admit returns true if e should be emitted, setting its sequence number and keyframe flag
+func (d *deltaFilter) admit(e *Event, now time.Time) bool {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if d.policy == nil {
+		return true
+	}
+
+	data, _ := e.Data.(map[string]interface{})
+	keyframe := d.keyframe.IsZero() || now.Sub(d.keyframe) >= d.policy.KeyframeInterval
+	if !keyframe && e.Name == EventStatus && e.Message == d.message && !d.statsChanged(data) {
+		d.skipped++
+		return false
+	}
+
+	if keyframe {
+		d.keyframe = now
+	}
+	d.seq++
+	e.Seq, e.Keyframe = d.seq, keyframe
+	d.message = e.Message
+	d.stats = make(map[string]interface{}, len(d.policy.Stats))
+	for key := range d.policy.Stats {
+		if v, ok := lookupStat(data, key); ok {
+			d.stats[key] = v
+		}
+	}
+	return true
+}
+
+// statsChanged returns true if a watched stat appeared, disappeared or changed beyond its tolerance.
+// Non numeric stats change whenever they are not deeply equal.
+func (d *deltaFilter) statsChanged(data map[string]interface{}) bool {
+	for key, tolerance := range d.policy.Stats {
+		v, ok := lookupStat(data, key)
+		last, had := d.stats[key]
+		if ok != had {
+			return true
+		}
+		if !ok {
+			continue
+		}
+		f, numeric := promValue(v)
+		lastF, lastNumeric := promValue(last)
+		if numeric && lastNumeric {
+			if diff := f - lastF; diff > tolerance || -diff > tolerance {
+				return true
+			}
+			continue
+		}
+		if !reflect.DeepEqual(v, last) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package health
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReporterDeltaReporting(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	r.RemoveSink(KafkaSinkName)
+	var buf bytes.Buffer
+	r.AddSink(NewWriterSink("buf", &buf))
+	r.SetDeltaReporting(&DeltaPolicy{KeyframeInterval: time.Hour, Stats: map[string]float64{"lag": 10, "db.status": 0}})
+
+	r.SetHealth(Green, "ok")
+	r.AddStat("lag", 100)
+	r.AddStat("db", map[string]interface{}{"status": "up"})
+	r.AddStat("requests", 1)
+	r.ReportHealth() // startup keyframe
+	r.AddStat("requests", 2)
+	r.ReportHealth() // unwatched stat, skipped
+	r.AddStat("lag", 105)
+	r.ReportHealth() // within tolerance, skipped
+	r.AddStat("lag", 111)
+	r.ReportHealth() // 11 from the last emitted 100
+	r.AddStat("db", map[string]interface{}{"status": "down"})
+	r.ReportHealth()
+	r.SetHealth(Green, "ok, degraded db")
+	r.ReportHealth()
+	r.SetHealth(Red, "ok, degraded db")
+	r.ReportHealth() // transition
+	r.ReportHealth() // skipped
+
+	events := sinkEvents(t, &buf)
+	assert.Len(t, events, 5)
+	for i, e := range events {
+		assert.Equal(t, uint64(i+1), e.Seq)
+	}
+	assert.True(t, events[0].Keyframe)
+	assert.False(t, events[1].Keyframe)
+	assert.Equal(t, EventTransition, events[4].Name)
+	assert.Equal(t, uint64(3), r.DeltaSkipped())
+
+	// disabling emits every report, without a sequence
+	r.SetDeltaReporting(nil)
+	buf.Reset()
+	r.ReportHealth()
+	events = sinkEvents(t, &buf)
+	assert.Len(t, events, 1)
+	assert.Equal(t, uint64(0), events[0].Seq)
+}
+
+func TestDeltaFilterKeyframes(t *testing.T) {
+	d := deltaFilter{policy: &DeltaPolicy{KeyframeInterval: time.Minute}}
+	now := time.Unix(1000, 0)
+	admit := func(after time.Duration) *Event {
+		now = now.Add(after)
+		e := &Event{Name: EventStatus, State: Green, Data: map[string]interface{}{}}
+		if !d.admit(e, now) {
+			return nil
+		}
+		return e
+	}
+
+	e := admit(0)
+	assert.True(t, e.Keyframe)
+	assert.Nil(t, admit(time.Second*30))
+	assert.Nil(t, admit(time.Second*29))
+	e = admit(time.Second)
+	assert.True(t, e.Keyframe)
+	assert.Equal(t, uint64(2), e.Seq)
+	assert.Nil(t, admit(time.Second))
+}
+
+func TestDeltaKeyframesKeepAggregatorFresh(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	r.SetDeltaReporting(&DeltaPolicy{})
+	now := time.Unix(1000, 0)
+	a := NewAggregator(DefaultStaleAfter)
+	a.now = func() time.Time { return now }
+
+	// an unchanged instance reporting every 30s for an hour is never stale
+	emitted := 0
+	for i := 0; i < 120; i++ {
+		e := Event{Service: "api", Hostname: "h1", Name: EventStatus, State: Green, Timestamp: now.Unix(), Data: map[string]interface{}{}}
+		if r.delta.admit(&e, now) {
+			emitted++
+			a.Observe(e, "health.api")
+		}
+		now = now.Add(time.Second * 30)
+		assert.Empty(t, a.Summary().Stale, "at %ds", i*30)
+	}
+	assert.Equal(t, 30, emitted)
+}
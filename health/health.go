@@ -82,6 +82,10 @@ type Event struct {
 	State     State  `json:"state"`     // Enumeration of health
 	Message   string `json:"msg"`       // User actionable message
 
+	Seq      uint64     `json:"seq,omitempty"`      // Sequence number, only set with SetDeltaReporting
+	Keyframe bool       `json:"keyframe,omitempty"` // Full snapshot, only set with SetDeltaReporting
+	Sig      *Signature `json:"sig,omitempty"`      // Only set with SetSigner, see Verifier
+
 	Data interface{} `json:"data,omitempty"` // Service-specific data
 }
 
@@ -109,6 +113,9 @@ type HealthMsg struct {
 
 //var HealthTopicSuffix = "system.health"
 
+// GetHostHealthBytes returns the raw gopsutil host data as json, errors are only logged.
+//
+// Deprecated: use a HostCollector with ReportHost, which reports typed deltas and errors per section.
 func GetHostHealthBytes(now time.Time) []byte {
 	v, _ := mem.VirtualMemory()
 
@@ -168,6 +175,23 @@ type Reporter struct {
 	message         string
 	stats           sync.Map
 	persistentStats sync.Map
+	metrics         sync.Map
+	checks          sync.Map
+	checkMux        sync.Mutex // serialises registering and unregistering checks
+	rules           ruleSet
+	watchdogs       sync.Map
+	unknownKicks    sync.Map // names of unregistered watchdogs already warned about
+	maintenance     maintenance
+	probes          ProbeConfig
+	transitions     transitionLog
+	damping         damper
+	components      components
+	delta           deltaFilter
+	signer          *Signer
+	slo             *SLOTracker
+	sinks           []*sinkEntry
+	sinkMux         sync.Mutex
+	created         time.Time
 	stdOutFallback  bool
 
 	statFns             sync.Map
@@ -177,7 +201,12 @@ type Reporter struct {
 	kafkaHealthy        bool
 	kafkaErr            error
 	doneCh              chan struct{}
-	reportTicker        *time.Ticker
+	reconfigCh          chan struct{}
+	changeCh            chan struct{}
+	reportInterval      time.Duration
+	final               State
+	finalMsg            string
+	run                 runState
 	sc                  stream.KafkaStreamConfig
 	topic               string
 }
@@ -189,23 +218,31 @@ func NewReporter(service, prefix, brokers string, errfn func(err error)) *Report
 	hn, _ := os.Hostname()
 	info := build.GetInfo(service)
 
-	return &Reporter{
+	r := &Reporter{
 		service:             service,
 		hostname:            hn,
 		version:             info.Version,
 		state:               StateDefault,
+		probes:              DefaultProbeConfig(),
+		transitions:         transitionLog{max: DefaultTransitionHistory},
+		created:             time.Now(),
 		healthCheckInterval: time.Minute,
 		maxCheckInterval:    MaxKafkaHealthCheckInterval,
 		doneCh:              make(chan struct{}),
-		reportTicker:        &time.Ticker{},
+		reconfigCh:          make(chan struct{}, 1),
+		changeCh:            make(chan struct{}, 1),
+		final:               Gray,
 		sc:                  &stream.StreamConfig{Brokers: brokers, Prefix: prefix},
 		Errfn:               errfn}
+	r.AddSink(&kafkaSink{r: r})
+	return r
 }
 
 // GetKafkaLogWriter returns an io.Writer which can be used to produce to the service log stream,
 // *Note* if you use pass this io.Writer to log.SetOutput(), be careful not to call any log functions
 //   in the error callback, otherwise you may cause a deadlock.
 // this stream is always `<prefix>.service.log`
+// See NewLogger for leveled, structured logging that never blocks.
 func (r *Reporter) GetKafkaLogWriter() io.Writer {
 	svcTopic := fmt.Sprintf("%s.log", r.service)
 	return newLogWriter(svcTopic, r)
@@ -219,10 +256,20 @@ func (r *Reporter) SetStdOutFallback(b bool) {
 
 // SetTopic provides the ability to override the default topic.
 // if never called, Initialize() will default the topic to `health.<service name>`
+// It may be called at any time, the next report is produced to the new topic.
 func (r *Reporter) SetTopic(topic string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
 	r.topic = topic
 }
 
+// Topic returns the topic health is produced to
+func (r *Reporter) Topic() string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.topic
+}
+
 func (r *Reporter) SetErrFn(efn func(err error)) {
 	r.Errfn = efn
 }
@@ -251,31 +298,48 @@ func (r *Reporter) Initialize() (bool, error) {
 		return false, err
 	}
 
-	var p *kafka.Producer
-	if r.sc.GetProducer() == nil {
-		if r.topic == "" {
-			r.topic = fmt.Sprintf("health.%s", r.service)
-		}
-		pd := r.sc.ProducerDefaults()
-		err := pd.SetKey("go.delivery.reports", true)
-		if err != nil {
-			return false, err
-		}
+	p, err := r.initProducer()
+	if err != nil {
+		return false, err
+	}
 
-		r.mux.Lock()
-		p, r.kafkaErr = r.sc.NewProducer(pd)
-		if r.kafkaErr == nil {
-			r.kafkaHealthy = true
-		}
-		r.mux.Unlock()
+	_, kafkaErr := r.KafkaHealthy()
+	if kafkaErr == nil {
+		r.ReportHealth()
+	}
+	go r.monitorKafkaErrors(r.doneCh)
+	go r.loop(r.doneCh)
+	return p != nil, kafkaErr
+}
+
+// initProducer creates a producer unless one is set, returning it if created.
+// An error creating the producer is kept as the kafka error, and returned if it is a configuration error,
+// i.e. no producer was created. Broker errors of a created producer are only kept, as they may be transient.
+func (r *Reporter) initProducer() (*kafka.Producer, error) {
+	if r.sc.GetProducer() != nil {
+		return nil, nil
+	}
+	r.mux.Lock()
+	if r.topic == "" {
+		r.topic = fmt.Sprintf("health.%s", r.service)
+	}
+	r.mux.Unlock()
+	pd := r.sc.ProducerDefaults()
+	err := pd.SetKey("go.delivery.reports", true)
+	if err != nil {
+		return nil, err
 	}
 
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	var p *kafka.Producer
+	p, r.kafkaErr = r.sc.NewProducer(pd)
 	if r.kafkaErr == nil {
-		r.ReportHealth()
+		r.kafkaHealthy = true
+	} else if p == nil {
+		return nil, r.kafkaErr
 	}
-	r.monitorKafkaErrors()
-	r.startHealthCheck(r.healthCheckInterval)
-	return p != nil, r.kafkaErr
+	return p, nil
 }
 
 // Health returns the current health as a populated Event object
@@ -293,26 +357,89 @@ func (r *Reporter) Health() Event {
 	}
 	// persistent stats first, so we override them.
 	r.persistentStats.Range(fn)
+	r.metrics.Range(func(k, rm interface{}) bool {
+		return fn(k, rm.(*registeredMetric).m.snapshot())
+	})
 	r.stats.Range(fn)
+
+	statuses := r.CheckStatuses()
+	if len(statuses) > 0 {
+		stats[ChecksDataKey] = statuses
+	}
+	matches := r.rules.lastMatches()
+	if len(matches) > 0 {
+		stats[RulesDataKey] = matches
+	}
+	state, message := aggregateRules(r.state, r.message, matches)
+	state, message = aggregateChecks(state, message, statuses)
+
+	watchdogs := r.WatchdogStatuses()
+	if len(watchdogs) > 0 {
+		stats[WatchdogsDataKey] = watchdogs
+	}
+	state, message = aggregateWatchdogs(state, message, watchdogs)
+
+	children, policy := r.components.health()
+	if len(children) > 0 {
+		stats[ComponentsDataKey] = children
+	}
+	state, message = aggregateComponents(state, message, policy, children)
+
+	if r.slo != nil {
+		slos := r.slo.Statuses()
+		if len(slos) > 0 {
+			stats[SLODataKey] = slos
+		}
+		state, message = aggregateSLO(state, message, slos)
+	}
+
+	// maintenance overrides everything, without touching the state set with SetHealth
+	if mw, ok := r.maintenance.active(time.Now()); ok {
+		stats[MaintenanceDataKey] = mw
+		state, message = Gray, mw.Reason
+	}
+
 	return Event{
 		Hostname:  r.hostname,
 		Timestamp: time.Now().Unix(),
 		Type:      "health",
-		Name:      "status",
+		Name:      EventStatus,
 		Service:   r.service,
 		Version:   r.version,
-		State:     r.state,
-		Message:   r.message,
+		State:     state,
+		Message:   message,
 		Data:      stats,
 	}
 }
 
-// SetHealth sets the current state and message
+// SetHealth sets the current state and message.
+// While reporting (see Run and Initialize), the reporting goroutine is woken to report a change of the
+// aggregated state (see Health) as a transition, so changes between interval reports aren't lost,
+// unless damping is enabled. Changes made before it wakes are reported together.
 func (r *Reporter) SetHealth(state State, message string) {
+	if r.setHealth(state, message) && !r.damping.enabled() {
+		select {
+		case r.changeCh <- struct{}{}:
+		default: // already pending
+		}
+	}
+}
+
+// reportChange reports the health if its aggregated state differs from the last reported one
+func (r *Reporter) reportChange() {
+	if last, ok := r.transitions.last(); ok && r.Health().State != last {
+		r.report(EventStatus)
+	}
+}
+
+// setHealth sets the state and message, returning true if the state changed
+func (r *Reporter) setHealth(state State, message string) bool {
 	r.mux.Lock()
 	defer r.mux.Unlock()
+	changed := r.state != state
 	r.state = state
 	r.message = message
+	return changed
 }
 
 // RegisterStatFn registers a function to be called before each call of ReportHealth.
@@ -353,9 +480,10 @@ func (r *Reporter) ClearStat(key string) {
 	r.stats.Delete(key)
 }
 
-// ClearStats clears all non persistent stats
+// ClearStats clears all non persistent stats, and resets all typed metrics
 func (r *Reporter) ClearStats() {
 	r.stats = sync.Map{}
+	r.resetMetrics()
 }
 
 // ClearAll clears all stats and persistent stats
@@ -370,57 +498,39 @@ func (r *Reporter) JsonStats() []byte {
 	return b
 }
 
-// ReportHealth sends the current health to kafka
+// ReportHealth sends the current health to every enabled sink, by default only kafka.
+// The first report is a startup event, and a report whose state differs from the last is a transition event.
 func (r *Reporter) ReportHealth() {
+	r.report(EventStatus)
+}
+
+func (r *Reporter) report(name string) {
 	r.statFns.Range(func(_, fun interface{}) bool {
 		if fn, ok := fun.(func(reporter IReporter)); ok {
 			fn(r)
 		}
 		return true
 	})
+	r.EvaluateRules()
 	h := r.Health()
+	now := time.Now()
+	if name != EventShutdown { // the final state is reported as is
+		r.damping.apply(&h, now)
+	}
+	r.transitions.observe(&h, name, now)
+	if !r.delta.admit(&h, now) {
+		return
+	}
+	if !r.sign(&h) {
+		return
+	}
 	b := safeMarshal(h)
-	_ = r.produce(r.stdOutFallback, r.topic, b) // we don't care about this error because it will get reported via callback
+	r.emit(h, b)
 }
 
-// StartIntervalReporting starts automatic reporting over the given interval.
+// StartIntervalReporting starts automatic reporting over the given interval, see SetReportInterval.
 func (r *Reporter) StartIntervalReporting(interval time.Duration) {
-	r.reportTicker.Stop() // just in case
-	r.reportTicker = time.NewTicker(interval)
-}
-
-func (r *Reporter) startHealthCheck(interval time.Duration) {
-	healthTicker := time.NewTicker(interval)
-
-	nextCheck := time.Time{}
-	backOff := time.Duration(0)
-	retry := 0
-	go func() {
-		for {
-			select {
-			case <-r.doneCh:
-				healthTicker.Stop()
-				return
-			case <-healthTicker.C:
-				if time.Now().After(nextCheck) {
-					if r.checkKafkaHealth() {
-						retry = 0 // reset count when health
-						backOff = time.Duration(0)
-					} else {
-						retry++
-						backOff = backOff + (interval * time.Duration(retry)) // Super simple backoff logic,
-						//log.Println("Backoff:", backOff)
-						if backOff > r.maxCheckInterval {
-							backOff = r.maxCheckInterval
-						}
-						nextCheck = time.Now().Add(backOff)
-					}
-				}
-			case <-r.reportTicker.C:
-				r.ReportHealth()
-			}
-		}
-	}()
+	r.SetReportInterval(interval)
 }
 
 // Stop interval reporting and close the kafka producer, after calling stop, the health reporter should no
@@ -432,29 +542,41 @@ func (r *Reporter) Stop() error {
 }
 
 // StopWithFinalState is the same as calling Stop() but takes in a final state and message,
-// that will be emitted before shutdown.
+// that will be emitted as a shutdown event, unless Run returned and already emitted one.
 func (r *Reporter) StopWithFinalState(final State, msg string) error {
-	r.SetHealth(final, msg)
-	r.ReportHealth()
-	if r.reportTicker != nil {
-		r.reportTicker.Stop()
+	r.run.mux.Lock()
+	r.run.stopped = true
+	shutdown := r.run.shutdown && !r.run.running
+	r.run.mux.Unlock()
+	if !shutdown {
+		r.setHealth(final, msg)
+		r.report(EventShutdown)
 	}
+	r.saveSLO()
 	if r.doneCh != nil {
 		close(r.doneCh)
 	}
+	r.closeSinks()
 	if r.sc.GetProducer() != nil {
 		r.sc.Flush(DefaultFlushTimeout)
 	}
 	return r.sc.Close()
 }
 
-func (r *Reporter) monitorKafkaErrors() {
-	go func() {
-		producer := r.sc.GetProducer()
-		if producer == nil {
+func (r *Reporter) monitorKafkaErrors(done <-chan struct{}) {
+	producer := r.sc.GetProducer()
+	if producer == nil {
+		return
+	}
+	events := producer.Events()
+	for {
+		select {
+		case <-done:
 			return
-		}
-		for e := range producer.Events() {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
 			switch ev := e.(type) {
 			case kafka.Error:
 				if ev.Code() == kafka.ErrAllBrokersDown {
@@ -465,12 +587,14 @@ func (r *Reporter) monitorKafkaErrors() {
 				}
 			}
 		}
-	}()
+	}
 }
 
 // KafkaHealthy returns true if kafka is healthy, false if it isn't, and the last error associated with
 // the unhealthy state
 func (r *Reporter) KafkaHealthy() (bool, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
 	return r.kafkaHealthy, r.kafkaErr
 }
 
@@ -495,7 +619,7 @@ func (r *Reporter) produce(fallback bool, topic string, message []byte) error {
 	fullTopic := r.sc.FullTopic(topic)
 	producer := r.sc.GetProducer()
 
-	healthy := r.kafkaHealthy
+	healthy, _ := r.KafkaHealthy()
 
 	var produceErr error
 	if producer != nil { // producer can be nil if Initialize has errors
@@ -529,7 +653,7 @@ func (r *Reporter) produce(fallback bool, topic string, message []byte) error {
 // HealthHandler return the current health on demand
 func (r *Reporter) HealthHandler(w http.ResponseWriter, req *http.Request) {
 	p := req.URL.Query().Get("pretty")
-	out := safeMarshal(r.Health())
+	out := safeMarshal(r.dampedHealth())
 	if pretty, _ := strconv.ParseBool(p); pretty {
 		out = util.JsonPrettyPrint(out)
 	}
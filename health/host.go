@@ -0,0 +1,372 @@
+package health
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/shirou/gopsutil/host"
+	"github.com/shirou/gopsutil/load"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/shirou/gopsutil/net"
+)
+
+// EventHost is the name of host snapshot events, see HostEvent
+const EventHost = "host"
+
+// Host snapshot sections, as used in HostSnapshot.Errors
+const (
+	SectionCPU    = "cpu"
+	SectionLoad   = "load"
+	SectionMemory = "memory"
+	SectionSwap   = "swap"
+	SectionDisks  = "disks"
+	SectionNet    = "net"
+	SectionUptime = "uptime"
+)
+
+// DefaultExcludeFsTypes are pseudo and virtual filesystems left out of HostSnapshot.Disks
+var DefaultExcludeFsTypes = []string{"tmpfs", "devtmpfs", "devfs", "overlay", "squashfs", "proc", "sysfs",
+	"cgroup", "cgroup2", "autofs", "nsfs", "tracefs", "debugfs", "fuse.lxcfs"}
+
+// HostCollectorOptions controls what a HostCollector reports, zero values mean the defaults
+type HostCollectorOptions struct {
+	IncludeFsTypes []string // only report disks of these filesystem types
+	ExcludeFsTypes []string // don't report disks of these filesystem types, DefaultExcludeFsTypes if nil
+	Interfaces     []string // only report these network interfaces
+	Loopback       bool     // report loopback interfaces
+}
+
+// HostSnapshot is a typed sample of the host, sections that failed are nil (or empty) and listed in Errors
+type HostSnapshot struct {
+	Timestamp int64             `json:"timestamp"`
+	CPU       *CPUStats         `json:"cpu,omitempty"`
+	Load      *load.AvgStat     `json:"load,omitempty"`
+	Memory    *MemoryStats      `json:"memory,omitempty"`
+	Swap      *MemoryStats      `json:"swap,omitempty"`
+	Disks     []DiskStats       `json:"disks,omitempty"`
+	Net       []NetStats        `json:"net,omitempty"`
+	Uptime    uint64            `json:"uptime,omitempty"` // seconds
+	Errors    map[string]string `json:"errors,omitempty"` // by section
+}
+
+// CPUStats is the utilization of all cores since the previous sample (or since boot for the first sample), in percent
+type CPUStats struct {
+	Cores   int     `json:"cores"`
+	Percent float64 `json:"percent"` // busy, i.e. not idle or iowait
+	User    float64 `json:"user"`
+	System  float64 `json:"system"`
+	Iowait  float64 `json:"iowait"`
+	Steal   float64 `json:"steal"`
+	Idle    float64 `json:"idle"`
+}
+
+type MemoryStats struct {
+	Total       uint64  `json:"total"`
+	Used        uint64  `json:"used"`
+	Available   uint64  `json:"available,omitempty"` // not set for swap
+	UsedPercent float64 `json:"used_percent"`
+}
+
+type DiskStats struct {
+	Mountpoint        string  `json:"mountpoint"`
+	Device            string  `json:"device"`
+	FsType            string  `json:"fstype"`
+	Total             uint64  `json:"total"`
+	Used              uint64  `json:"used"`
+	Free              uint64  `json:"free"`
+	UsedPercent       float64 `json:"used_percent"`
+	InodesUsedPercent float64 `json:"inodes_used_percent"`
+}
+
+// NetStats are the counters of an interface, rates are since the previous sample and 0 on the first
+type NetStats struct {
+	Name        string  `json:"name"`
+	BytesSent   uint64  `json:"bytes_sent"`
+	BytesRecv   uint64  `json:"bytes_recv"`
+	SentPerSec  float64 `json:"sent_per_sec"` // bytes
+	RecvPerSec  float64 `json:"recv_per_sec"` // bytes
+	PacketsSent uint64  `json:"packets_sent"`
+	PacketsRecv uint64  `json:"packets_recv"`
+	Errin       uint64  `json:"errin"`
+	Errout      uint64  `json:"errout"`
+	Dropin      uint64  `json:"dropin"`
+	Dropout     uint64  `json:"dropout"`
+}
+
+// HostError lists the sections of a HostSnapshot that could not be collected
+type HostError map[string]error
+
+func (he HostError) Error() string {
+	sections := make([]string, 0, len(he))
+	for s := range he {
+		sections = append(sections, s)
+	}
+	sort.Strings(sections)
+	msgs := make([]string, 0, len(sections))
+	for _, s := range sections {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", s, he[s]))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// hostSource abstracts gopsutil, for testing
+type hostSource struct {
+	cpuTimes   func() ([]cpu.TimesStat, error)
+	loadAvg    func() (*load.AvgStat, error)
+	virtualMem func() (*mem.VirtualMemoryStat, error)
+	swapMem    func() (*mem.SwapMemoryStat, error)
+	partitions func() ([]disk.PartitionStat, error)
+	usage      func(path string) (*disk.UsageStat, error)
+	netIO      func() ([]net.IOCountersStat, error)
+	netIfaces  func() ([]net.InterfaceStat, error)
+	uptime     func() (uint64, error)
+}
+
+var gopsutilSource = hostSource{
+	cpuTimes:   func() ([]cpu.TimesStat, error) { return cpu.Times(false) },
+	loadAvg:    load.Avg,
+	virtualMem: mem.VirtualMemory,
+	swapMem:    mem.SwapMemory,
+	partitions: func() ([]disk.PartitionStat, error) { return disk.Partitions(false) },
+	usage:      disk.Usage,
+	netIO:      func() ([]net.IOCountersStat, error) { return net.IOCounters(true) },
+	netIfaces:  net.Interfaces,
+	uptime:     host.Uptime,
+}
+
+// HostCollector takes HostSnapshots, keeping the previous sample to compute cpu and network deltas
+type HostCollector struct {
+	opts HostCollectorOptions
+	src  hostSource
+
+	mux      sync.Mutex
+	lastCPU  *cpu.TimesStat
+	lastNet  map[string]net.IOCountersStat
+	lastTime time.Time // of lastNet
+}
+
+func NewHostCollector(opts HostCollectorOptions) *HostCollector {
+	if opts.ExcludeFsTypes == nil {
+		opts.ExcludeFsTypes = DefaultExcludeFsTypes
+	}
+	return &HostCollector{opts: opts, src: gopsutilSource}
+}
+
+// Collect takes a snapshot, the error is a HostError if any section failed
+func (c *HostCollector) Collect() (HostSnapshot, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	now := time.Now()
+	snap := HostSnapshot{Timestamp: now.Unix()}
+	errs := make(HostError)
+	var err error
+
+	if snap.CPU, err = c.cpu(); err != nil {
+		errs[SectionCPU] = err
+	}
+	if snap.Load, err = c.src.loadAvg(); err != nil {
+		errs[SectionLoad] = err
+	}
+	if vm, err := c.src.virtualMem(); err != nil {
+		errs[SectionMemory] = err
+	} else {
+		snap.Memory = &MemoryStats{Total: vm.Total, Used: vm.Used, Available: vm.Available, UsedPercent: vm.UsedPercent}
+	}
+	if sm, err := c.src.swapMem(); err != nil {
+		errs[SectionSwap] = err
+	} else {
+		snap.Swap = &MemoryStats{Total: sm.Total, Used: sm.Used, UsedPercent: sm.UsedPercent}
+	}
+	if snap.Disks, err = c.disks(); err != nil {
+		errs[SectionDisks] = err
+	}
+	if snap.Net, err = c.net(now); err != nil {
+		errs[SectionNet] = err
+	}
+	if snap.Uptime, err = c.src.uptime(); err != nil {
+		errs[SectionUptime] = err
+	}
+
+	if len(errs) == 0 {
+		return snap, nil
+	}
+	snap.Errors = make(map[string]string, len(errs))
+	for s, e := range errs {
+		snap.Errors[s] = e.Error()
+	}
+	return snap, errs
+}
+
+func (c *HostCollector) cpu() (*CPUStats, error) {
+	times, err := c.src.cpuTimes()
+	if err != nil {
+		return nil, err
+	}
+	if len(times) == 0 {
+		return nil, fmt.Errorf("no cpu times")
+	}
+	cur := times[0]
+	delta := cur
+	if c.lastCPU != nil {
+		prev := *c.lastCPU
+		delta.User -= prev.User
+		delta.System -= prev.System
+		delta.Nice -= prev.Nice
+		delta.Iowait -= prev.Iowait
+		delta.Irq -= prev.Irq
+		delta.Softirq -= prev.Softirq
+		delta.Steal -= prev.Steal
+		delta.Idle -= prev.Idle
+	}
+	c.lastCPU = &cur
+
+	stats := &CPUStats{Cores: runtime.NumCPU()}
+	total := delta.Total()
+	if total <= 0 { // no time passed
+		return stats, nil
+	}
+	pct := func(v float64) float64 { return v / total * 100 }
+	stats.User = pct(delta.User + delta.Nice)
+	stats.System = pct(delta.System + delta.Irq + delta.Softirq)
+	stats.Iowait = pct(delta.Iowait)
+	stats.Steal = pct(delta.Steal)
+	stats.Idle = pct(delta.Idle)
+	stats.Percent = 100 - stats.Idle - stats.Iowait
+	return stats, nil
+}
+
+func (c *HostCollector) disks() ([]DiskStats, error) {
+	parts, err := c.src.partitions()
+	if err != nil {
+		return nil, err
+	}
+	var out []DiskStats
+	var errs []string
+	seen := make(map[string]bool)
+	for _, p := range parts {
+		if seen[p.Mountpoint] || !c.includeFs(p.Fstype) {
+			continue
+		}
+		seen[p.Mountpoint] = true
+		u, err := c.src.usage(p.Mountpoint)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", p.Mountpoint, err))
+			continue
+		}
+		out = append(out, DiskStats{
+			Mountpoint:        p.Mountpoint,
+			Device:            p.Device,
+			FsType:            p.Fstype,
+			Total:             u.Total,
+			Used:              u.Used,
+			Free:              u.Free,
+			UsedPercent:       u.UsedPercent,
+			InodesUsedPercent: u.InodesUsedPercent,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Mountpoint < out[j].Mountpoint })
+	if len(errs) > 0 {
+		return out, fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return out, nil
+}
+
+func (c *HostCollector) includeFs(fs string) bool {
+	if len(c.opts.IncludeFsTypes) > 0 {
+		return containsString(c.opts.IncludeFsTypes, fs)
+	}
+	return !containsString(c.opts.ExcludeFsTypes, fs)
+}
+
+func (c *HostCollector) net(now time.Time) ([]NetStats, error) {
+	counters, err := c.src.netIO()
+	if err != nil {
+		return nil, err
+	}
+
+	loopback := make(map[string]bool)
+	if !c.opts.Loopback {
+		ifaces, err := c.src.netIfaces()
+		if err != nil {
+			return nil, err
+		}
+		for _, iface := range ifaces {
+			if containsString(iface.Flags, "loopback") {
+				loopback[iface.Name] = true
+			}
+		}
+	}
+
+	elapsed := now.Sub(c.lastTime).Seconds()
+	cur := make(map[string]net.IOCountersStat, len(counters))
+	var out []NetStats
+	for _, io := range counters {
+		if loopback[io.Name] || (len(c.opts.Interfaces) > 0 && !containsString(c.opts.Interfaces, io.Name)) {
+			continue
+		}
+		cur[io.Name] = io
+		ns := NetStats{
+			Name:        io.Name,
+			BytesSent:   io.BytesSent,
+			BytesRecv:   io.BytesRecv,
+			PacketsSent: io.PacketsSent,
+			PacketsRecv: io.PacketsRecv,
+			Errin:       io.Errin,
+			Errout:      io.Errout,
+			Dropin:      io.Dropin,
+			Dropout:     io.Dropout,
+		}
+		// counters reset when an interface is recreated, skip the rate rather than report a bogus one
+		if prev, ok := c.lastNet[io.Name]; ok && elapsed > 0 && io.BytesSent >= prev.BytesSent && io.BytesRecv >= prev.BytesRecv {
+			ns.SentPerSec = float64(io.BytesSent-prev.BytesSent) / elapsed
+			ns.RecvPerSec = float64(io.BytesRecv-prev.BytesRecv) / elapsed
+		}
+		out = append(out, ns)
+	}
+	c.lastNet, c.lastTime = cur, now
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// HostEvent wraps a HostSnapshot in an Event named EventHost, carrying the current state and message
+// of the reporter as reported by Health
+func (r *Reporter) HostEvent(snap HostSnapshot) Event {
+	h := r.Health()
+	return Event{
+		Hostname:  r.hostname,
+		Timestamp: snap.Timestamp,
+		Type:      EventType,
+		Name:      EventHost,
+		Service:   r.service,
+		Version:   r.version,
+		State:     h.State,
+		Message:   h.Message,
+		Data:      snap,
+	}
+}
+
+// ReportHost collects a snapshot and sends it to every enabled sink as a host event.
+// The snapshot is sent even if some sections failed, the returned error lists them.
+func (r *Reporter) ReportHost(c *HostCollector) error {
+	snap, err := c.Collect()
+	e := r.HostEvent(snap)
+	r.sign(&e)
+	r.emit(e, safeMarshal(e))
+	return err
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package health
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/shirou/gopsutil/load"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/shirou/gopsutil/net"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeHost is a hostSource whose counters advance on every call
+func fakeHost() (hostSource, *cpu.TimesStat, *net.IOCountersStat) {
+	times := &cpu.TimesStat{User: 100, System: 50, Idle: 850}
+	eth := &net.IOCountersStat{Name: "eth0", BytesSent: 1000, BytesRecv: 2000, Errin: 1}
+	src := hostSource{
+		cpuTimes: func() ([]cpu.TimesStat, error) { return []cpu.TimesStat{*times}, nil },
+		loadAvg:  func() (*load.AvgStat, error) { return &load.AvgStat{Load1: 1, Load5: 0.5, Load15: 0.25}, nil },
+		virtualMem: func() (*mem.VirtualMemoryStat, error) {
+			return &mem.VirtualMemoryStat{Total: 100, Used: 40, Available: 60, UsedPercent: 40}, nil
+		},
+		swapMem: func() (*mem.SwapMemoryStat, error) { return nil, errors.New("no swap") },
+		partitions: func() ([]disk.PartitionStat, error) {
+			return []disk.PartitionStat{
+				{Device: "/dev/sda1", Mountpoint: "/", Fstype: "ext4"},
+				{Device: "tmpfs", Mountpoint: "/run", Fstype: "tmpfs"},
+				{Device: "/dev/sdb1", Mountpoint: "/data", Fstype: "xfs"},
+				{Device: "/dev/sda1", Mountpoint: "/", Fstype: "ext4"}, // bind mounts repeat
+			}, nil
+		},
+		usage: func(path string) (*disk.UsageStat, error) {
+			return &disk.UsageStat{Path: path, Total: 10, Used: 5, Free: 5, UsedPercent: 50}, nil
+		},
+		netIO: func() ([]net.IOCountersStat, error) {
+			return []net.IOCountersStat{*eth, {Name: "lo", BytesSent: 5, BytesRecv: 5}}, nil
+		},
+		netIfaces: func() ([]net.InterfaceStat, error) {
+			return []net.InterfaceStat{{Name: "eth0", Flags: []string{"up"}}, {Name: "lo", Flags: []string{"up", "loopback"}}}, nil
+		},
+		uptime: func() (uint64, error) { return 3600, nil },
+	}
+	return src, times, eth
+}
+
+func TestHostCollector(t *testing.T) {
+	src, times, eth := fakeHost()
+	c := NewHostCollector(HostCollectorOptions{})
+	c.src = src
+
+	snap, err := c.Collect()
+	he, ok := err.(HostError)
+	assert.True(t, ok)
+	assert.Len(t, he, 1)
+	assert.EqualError(t, err, "swap: no swap")
+	assert.Equal(t, map[string]string{SectionSwap: "no swap"}, snap.Errors)
+	assert.Nil(t, snap.Swap)
+
+	// the first sample is since boot
+	assert.InDelta(t, 15, snap.CPU.Percent, 0.001)
+	assert.InDelta(t, 10, snap.CPU.User, 0.001)
+	assert.Equal(t, 0.5, snap.Load.Load5)
+	assert.Equal(t, &MemoryStats{Total: 100, Used: 40, Available: 60, UsedPercent: 40}, snap.Memory)
+	assert.Equal(t, uint64(3600), snap.Uptime)
+
+	// pseudo filesystems and repeated mounts are skipped
+	assert.Len(t, snap.Disks, 2)
+	assert.Equal(t, "/", snap.Disks[0].Mountpoint)
+	assert.Equal(t, "/data", snap.Disks[1].Mountpoint)
+
+	// loopback is skipped, and the first sample has no rate
+	assert.Len(t, snap.Net, 1)
+	assert.Equal(t, "eth0", snap.Net[0].Name)
+	assert.Equal(t, uint64(1), snap.Net[0].Errin)
+	assert.Zero(t, snap.Net[0].SentPerSec)
+
+	// later samples are deltas
+	times.User += 60
+	times.Idle += 40
+	eth.BytesSent += 1000
+	c.lastTime = c.lastTime.Add(-time.Second)
+	snap, _ = c.Collect()
+	assert.InDelta(t, 60, snap.CPU.Percent, 0.001)
+	assert.InDelta(t, 40, snap.CPU.Idle, 0.001)
+	assert.True(t, snap.Net[0].SentPerSec > 0 && snap.Net[0].SentPerSec <= 1000)
+	assert.Zero(t, snap.Net[0].RecvPerSec)
+
+	// a failed read doesn't move the start of the next rate
+	netIO := src.netIO
+	c.src.netIO = func() ([]net.IOCountersStat, error) { return nil, errors.New("no counters") }
+	c.lastTime = c.lastTime.Add(-time.Second * 2)
+	snap, _ = c.Collect()
+	assert.Nil(t, snap.Net)
+	c.src.netIO = netIO
+	eth.BytesSent += 2000
+	snap, _ = c.Collect()
+	assert.True(t, snap.Net[0].SentPerSec > 0 && snap.Net[0].SentPerSec <= 1000)
+}
+
+func TestHostCollectorFilters(t *testing.T) {
+	src, _, _ := fakeHost()
+	c := NewHostCollector(HostCollectorOptions{IncludeFsTypes: []string{"xfs"}, Interfaces: []string{"lo"}, Loopback: true})
+	c.src = src
+
+	snap, _ := c.Collect()
+	assert.Len(t, snap.Disks, 1)
+	assert.Equal(t, "xfs", snap.Disks[0].FsType)
+	assert.Len(t, snap.Net, 1)
+	assert.Equal(t, "lo", snap.Net[0].Name)
+}
+
+func TestReportHost(t *testing.T) {
+	src, _, _ := fakeHost()
+	c := NewHostCollector(HostCollectorOptions{})
+	c.src = src
+
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	r.RemoveSink(KafkaSinkName)
+	var buf bytes.Buffer
+	r.AddSink(NewWriterSink("buf", &buf))
+	r.SetHealth(Green, "ok")
+
+	assert.Error(t, r.ReportHost(c))
+	events := sinkEvents(t, &buf)
+	assert.Len(t, events, 1)
+	assert.Equal(t, EventHost, events[0].Name)
+	assert.Equal(t, EventType, events[0].Type)
+	assert.Equal(t, Green, events[0].State)
+	data := events[0].Data.(map[string]interface{})
+	assert.Contains(t, data, "cpu")
+	assert.Contains(t, data, "errors")
+
+	// host events are not state transitions
+	assert.Empty(t, r.Transitions())
+
+	// the state is the aggregated one
+	buf.Reset()
+	r.StartMaintenance("upgrade", time.Minute)
+	assert.Error(t, r.ReportHost(c))
+	events = sinkEvents(t, &buf)
+	assert.Equal(t, Gray, events[0].State)
+	assert.Equal(t, r.Health().Message, events[0].Message)
+	assert.NotEqual(t, "ok", events[0].Message)
+}
+
+func TestHostCollectorLive(t *testing.T) {
+	c := NewHostCollector(HostCollectorOptions{})
+	snap, _ := c.Collect()
+	assert.NotNil(t, snap.CPU)
+	assert.NotNil(t, snap.Memory)
+	assert.NotZero(t, snap.Timestamp)
+}
@@ -0,0 +1,204 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrReporterRunning = errors.New("reporter is already running") // returned by Run when already running
+	ErrReporterStopped = errors.New("reporter is stopped")         // returned by Run after Stop
+)
+
+// runState tracks the current Run
+type runState struct {
+	mux      sync.Mutex
+	running  bool
+	done     <-chan struct{}
+	wg       *sync.WaitGroup
+	stopped  bool // by Stop, which closes the sinks and producer
+	shutdown bool // the last Run reported its shutdown
+}
+
+// Run creates the producer (unless one is set), reports a startup event, and then runs interval reporting,
+// kafka health checks and registered checks until ctx is done. It then reports the final state
+// (see SetFinalState), which is kept until set again, flushes the producer and returns once every goroutine
+// it started has stopped.
+// Configuration errors, e.g. when no producer can be created, are returned before anything is reported,
+// while broker errors are sent to Errfn and retried by the kafka health checks.
+// Run may be called again after it returns, the producer and sinks are kept until Stop,
+// after which Run returns ErrReporterStopped.
+//
+// Use either Run or Initialize, not both.
+func (r *Reporter) Run(ctx context.Context) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+
+	r.run.mux.Lock()
+	switch {
+	case r.run.stopped:
+		r.run.mux.Unlock()
+		return ErrReporterStopped
+	case r.run.running:
+		r.run.mux.Unlock()
+		return ErrReporterRunning
+	}
+	r.run.running, r.run.done, r.run.wg, r.run.shutdown = true, ctx.Done(), &wg, false
+	r.run.mux.Unlock()
+
+	if _, err := r.initProducer(); err != nil {
+		r.run.mux.Lock()
+		r.run.running, r.run.done, r.run.wg = false, nil, nil
+		r.run.mux.Unlock()
+		return err
+	}
+	if _, kafkaErr := r.KafkaHealthy(); kafkaErr != nil {
+		r.errorHandler("kafka not available", kafkaErr)
+	}
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r.monitorKafkaErrors(ctx.Done())
+	}()
+	go func() {
+		defer wg.Done()
+		r.loop(ctx.Done())
+	}()
+	r.checkMux.Lock()
+	r.checks.Range(func(_, c interface{}) bool {
+		r.startCheck(c.(*healthCheck))
+		return true
+	})
+	r.checkMux.Unlock()
+
+	r.report(EventStartup)
+	<-ctx.Done()
+
+	// no goroutines are started once running is false, so it is safe to wait
+	r.run.mux.Lock()
+	r.run.running, r.run.done, r.run.wg = false, nil, nil
+	r.run.mux.Unlock()
+	wg.Wait()
+
+	r.mux.Lock()
+	final, msg := r.final, r.finalMsg
+	r.mux.Unlock()
+	r.setHealth(final, msg)
+	r.report(EventShutdown)
+	r.run.mux.Lock()
+	r.run.shutdown = true
+	r.run.mux.Unlock()
+	if r.sc.GetProducer() != nil {
+		r.sc.Flush(DefaultFlushTimeout)
+	}
+	return nil
+}
+
+// Running returns true while Run is running
+func (r *Reporter) Running() bool {
+	r.run.mux.Lock()
+	defer r.run.mux.Unlock()
+	return r.run.running
+}
+
+// SetFinalState sets the state and message reported when Run returns, Gray with an empty message by default
+func (r *Reporter) SetFinalState(final State, msg string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.final, r.finalMsg = final, msg
+}
+
+// SetReportInterval sets the interval of automatic reporting, taking effect immediately if running.
+// An interval < 1 disables interval reporting.
+func (r *Reporter) SetReportInterval(interval time.Duration) {
+	r.mux.Lock()
+	r.reportInterval = interval
+	r.mux.Unlock()
+	r.reconfigure()
+}
+
+// reconfigure wakes the running loop to pick up a new configuration
+func (r *Reporter) reconfigure() {
+	select {
+	case r.reconfigCh <- struct{}{}:
+	default: // already pending
+	}
+}
+
+// lifecycle returns the done channel and wait group goroutines should use, those of Run when running
+// and otherwise the reporter done channel, closed by Stop. r.run.mux must be held.
+func (r *Reporter) lifecycle() (<-chan struct{}, *sync.WaitGroup) {
+	if r.run.running {
+		return r.run.done, r.run.wg
+	}
+	return r.doneCh, nil
+}
+
+// loop runs interval reporting and kafka health checks until done
+func (r *Reporter) loop(done <-chan struct{}) {
+	r.mux.Lock()
+	interval := r.healthCheckInterval
+	r.mux.Unlock()
+	healthTicker := time.NewTicker(interval)
+	defer healthTicker.Stop()
+
+	var reportTicker *time.Ticker
+	var reportC <-chan time.Time
+	resetReport := func() {
+		if reportTicker != nil {
+			reportTicker.Stop()
+			reportTicker, reportC = nil, nil
+		}
+		r.mux.Lock()
+		d := r.reportInterval
+		r.mux.Unlock()
+		if d > 0 {
+			reportTicker = time.NewTicker(d)
+			reportC = reportTicker.C
+		}
+	}
+	resetReport()
+	defer func() {
+		if reportTicker != nil {
+			reportTicker.Stop()
+		}
+	}()
+
+	nextCheck := time.Time{}
+	backOff := time.Duration(0)
+	retry := 0
+	for {
+		select {
+		case <-done:
+			return
+		case <-r.reconfigCh:
+			resetReport()
+		case <-r.changeCh:
+			r.reportChange()
+		case <-healthTicker.C:
+			if time.Now().After(nextCheck) {
+				if r.checkKafkaHealth() {
+					retry = 0 // reset count when health
+					backOff = time.Duration(0)
+				} else {
+					retry++
+					backOff = backOff + (interval * time.Duration(retry)) // Super simple backoff logic,
+					if backOff > r.maxCheckInterval {
+						backOff = r.maxCheckInterval
+					}
+					nextCheck = time.Now().Add(backOff)
+				}
+			}
+		case <-reportC:
+			r.ReportHealth()
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	streammocks "github.com/atsu/goat/stream/mocks"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type produced struct {
+	topic string
+	event Event
+}
+
+// mockReporter returns a reporter producing through a mocked stream config with the `test` prefix,
+// each produced message is passed to produce (if set), whose error is returned by Produce
+func mockReporter(produce func(topic string, value []byte) error) (*Reporter, *streammocks.KafkaStreamConfig) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	scMock := streammocks.NewProducingStreamConfig("test", produce)
+	scMock.On("Flush", DefaultFlushTimeout).Return(0)
+	r.sc = scMock
+	r.kafkaHealthy = true
+	return r, scMock
+}
+
+// runReporter returns a reporter whose produced events are sent to the returned channel
+func runReporter() (*Reporter, chan produced) {
+	out := make(chan produced, 1000)
+	r, _ := mockReporter(func(topic string, value []byte) error {
+		var e Event
+		if err := json.Unmarshal(value, &e); err == nil {
+			out <- produced{topic: topic, event: e}
+		}
+		return nil
+	})
+	r.SetTopic("health.test")
+	return r, out
+}
+
+// next returns the next produced event, failing after a second
+func next(t *testing.T, out chan produced) produced {
+	select {
+	case p := <-out:
+		return p
+	case <-time.After(time.Second):
+		t.Fatal("no event produced")
+	}
+	return produced{}
+}
+
+func TestReporterRun(t *testing.T) {
+	r, out := runReporter()
+	r.SetHealth(Green, "ok")
+	r.SetFinalState(Red, "gone")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error)
+	go func() { errCh <- r.Run(ctx) }()
+
+	p := next(t, out)
+	assert.Equal(t, EventStartup, p.event.Name)
+	assert.Equal(t, "test.health.test", p.topic)
+	assert.Eventually(t, r.Running, time.Second, time.Millisecond)
+	assert.Equal(t, ErrReporterRunning, r.Run(context.Background()))
+
+	cancel()
+	assert.NoError(t, <-errCh)
+	assert.False(t, r.Running())
+	p = next(t, out)
+	assert.Equal(t, EventShutdown, p.event.Name)
+	assert.Equal(t, Red, p.event.State)
+	assert.Equal(t, "gone", p.event.Message)
+}
+
+func TestReporterRunReconfigure(t *testing.T) {
+	r, out := runReporter()
+	r.SetHealth(Green, "ok")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, r.Run(ctx))
+	}()
+	assert.Equal(t, EventStartup, next(t, out).event.Name)
+
+	// interval reporting starts while running
+	r.SetReportInterval(time.Millisecond * 5)
+	assert.Equal(t, EventStatus, next(t, out).event.Name)
+
+	// and follows a topic change
+	r.SetTopic("health.other")
+	assert.Eventually(t, func() bool { return next(t, out).topic == "test.health.other" }, time.Second, time.Millisecond)
+
+	// and can be disabled
+	r.SetReportInterval(0)
+	time.Sleep(time.Millisecond * 20)
+	for len(out) > 0 {
+		<-out
+	}
+	time.Sleep(time.Millisecond * 20)
+	assert.Len(t, out, 0)
+
+	cancel()
+	wg.Wait()
+	assert.Equal(t, EventShutdown, next(t, out).event.Name)
+}
+
+func TestReporterRunRestart(t *testing.T) {
+	r, out := runReporter()
+	var runs int32
+	r.RegisterCheck("db", func(ctx context.Context) CheckResult {
+		atomic.AddInt32(&runs, 1)
+		return CheckResult{State: Green}
+	}, CheckOptions{Interval: time.Millisecond * 5})
+
+	for i := 0; i < 3; i++ {
+		r.SetHealth(Green, "ok") // the final state is kept until set again
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			assert.NoError(t, r.Run(ctx))
+		}()
+		assert.Equal(t, EventStartup, next(t, out).event.Name, "run %d", i)
+		before := atomic.LoadInt32(&runs)
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(&runs) > before }, time.Second, time.Millisecond)
+		cancel()
+		<-done
+		assert.Equal(t, EventShutdown, next(t, out).event.Name, "run %d", i)
+
+		// the checks stop with the run
+		stopped := atomic.LoadInt32(&runs)
+		time.Sleep(time.Millisecond * 20)
+		assert.Equal(t, stopped, atomic.LoadInt32(&runs))
+	}
+	// every startup and shutdown is recorded
+	assert.Len(t, r.Transitions(), 6)
+
+	// the shutdown was already reported, and the sinks are closed by Stop
+	assert.NoError(t, r.Stop())
+	assert.Len(t, out, 0)
+	assert.Equal(t, ErrReporterStopped, r.Run(context.Background()))
+}
+
+func TestReporterRunSetHealthTransitions(t *testing.T) {
+	r, out := runReporter()
+	r.SetHealth(Green, "ok")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, r.Run(ctx))
+	}()
+	assert.Equal(t, EventStartup, next(t, out).event.Name)
+
+	// no interval report in between, both changes are reported and recorded by the reporting goroutine
+	r.SetHealth(Red, "db down")
+	p := next(t, out)
+	assert.Equal(t, EventTransition, p.event.Name)
+	assert.Equal(t, Red, p.event.State)
+	r.SetHealth(Green, "db back")
+	p = next(t, out)
+	assert.Equal(t, EventTransition, p.event.Name)
+	assert.Equal(t, Green, p.event.State)
+	r.SetHealth(Green, "still ok") // not a state change
+	history := r.Transitions()
+	assert.Len(t, history, 3)
+	assert.Equal(t, Transition{Event: EventTransition, From: Green, To: Red, At: history[1].At, Duration: history[1].Duration, Reason: "db down"}, history[1])
+	assert.Equal(t, Red, history[2].From)
+	assert.Equal(t, "db back", history[2].Reason)
+
+	// a change hidden by maintenance is not reported
+	r.StartMaintenance("upgrade", time.Hour)
+	r.ReportHealth()
+	assert.Equal(t, Gray, next(t, out).event.State)
+	r.SetHealth(Red, "db down")
+	time.Sleep(time.Millisecond * 20)
+	assert.Len(t, out, 0)
+	r.EndMaintenance()
+	r.SetHealth(Green, "ok")
+	assert.Equal(t, Green, next(t, out).event.State)
+
+	// a stat fn setting the health doesn't report the change twice
+	r.RegisterStatFn("db", func(IReporter) { r.SetHealth(Yellow, "slow") })
+	r.ReportHealth()
+	assert.Equal(t, Yellow, next(t, out).event.State)
+	time.Sleep(time.Millisecond * 20)
+	assert.Len(t, out, 0)
+	r.ClearStatFns()
+
+	cancel()
+	<-done
+	assert.Equal(t, EventShutdown, next(t, out).event.Name)
+}
+
+func TestReporterRunConfigError(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	scMock := new(streammocks.KafkaStreamConfig)
+	scMock.On("GetProducer").Return(nil)
+	scMock.On("ProducerDefaults").Return(&kafka.ConfigMap{})
+	scMock.On("NewProducer", mock.AnythingOfType("*kafka.ConfigMap")).Return(nil, errors.New("invalid config"))
+	r.sc = scMock
+
+	assert.EqualError(t, r.Run(context.Background()), "invalid config")
+	assert.False(t, r.Running())
+	scMock.AssertNotCalled(t, "Produce", mock.Anything, mock.Anything)
+}
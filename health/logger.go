@@ -0,0 +1,302 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of a log line
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// Set compiles with the Flag.Value interface
+func (l *Level) Set(s string) error {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			*l = Level(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown Level: %s", s)
+}
+
+func (l Level) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.String())
+}
+
+func (l *Level) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return l.Set(s)
+}
+
+const (
+	DefaultLogBuffer        = 1024
+	DefaultLogBatch         = 100
+	DefaultLogFlushInterval = time.Second
+	DefaultLogErrorInterval = time.Minute
+)
+
+// LoggerOptions configures a Logger, zero values mean the defaults
+type LoggerOptions struct {
+	Level         Level         // lines below this level are discarded, defaults to LevelDebug
+	SampleEvery   int           // keep one in every SampleEvery debug and info lines, warnings and errors are always kept
+	BufferSize    int           // lines waiting to be sent, once full lines are dropped, DefaultLogBuffer if unset
+	BatchSize     int           // lines sent at once, DefaultLogBatch if unset
+	FlushInterval time.Duration // longest a line waits to be sent, DefaultLogFlushInterval if unset
+	ErrorInterval time.Duration // shortest time between produce failure reports to Errfn, DefaultLogErrorInterval if unset
+}
+
+// LogEntry is the json encoded form of a log line, compatible with the GetKafkaLogWriter format
+type LogEntry struct {
+	App       string                 `json:"app"`
+	Hostname  string                 `json:"hostname"`
+	Version   string                 `json:"version"`
+	Level     Level                  `json:"level"`
+	Timestamp int64                  `json:"timestamp"` // unix
+	Msg       string                 `json:"msg"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Logger is a leveled logger producing to `<prefix>.<service>.log` in the background.
+// Logging never blocks, when the buffer is full lines are dropped and counted, see Dropped.
+// Lines that fail to produce are counted too, see Failed, and reported to Errfn at most once per
+// ErrorInterval, so it is safe to log from Errfn.
+type Logger struct {
+	core   *loggerCore
+	fields map[string]interface{}
+}
+
+type loggerCore struct {
+	r       *Reporter
+	topic   string
+	opts    LoggerOptions
+	ch      chan LogEntry
+	dropped uint64
+	failed  uint64
+	sampled uint64 // lines seen for sampling
+
+	// produce failures not reported yet, only used by run
+	unreported uint64
+	lastErr    error
+	lastReport time.Time
+
+	closeOnce sync.Once
+	doneCh    chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewLogger creates a Logger producing to the service log stream, Close it before stopping the reporter
+func (r *Reporter) NewLogger(opts LoggerOptions) *Logger {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultLogBuffer
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultLogBatch
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultLogFlushInterval
+	}
+	if opts.ErrorInterval <= 0 {
+		opts.ErrorInterval = DefaultLogErrorInterval
+	}
+	core := &loggerCore{
+		r:      r,
+		topic:  fmt.Sprintf("%s.log", r.service),
+		opts:   opts,
+		ch:     make(chan LogEntry, opts.BufferSize),
+		doneCh: make(chan struct{}),
+	}
+	core.wg.Add(1)
+	go core.run()
+	return &Logger{core: core}
+}
+
+// With returns a logger adding the given key/value pairs to every line
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make(map[string]interface{}, len(l.fields)+len(kv)/2)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	addFields(fields, kv)
+	return &Logger{core: l.core, fields: fields}
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.Log(LevelDebug, msg, kv...) }
+func (l *Logger) Info(msg string, kv ...interface{})  { l.Log(LevelInfo, msg, kv...) }
+func (l *Logger) Warn(msg string, kv ...interface{})  { l.Log(LevelWarn, msg, kv...) }
+func (l *Logger) Error(msg string, kv ...interface{}) { l.Log(LevelError, msg, kv...) }
+
+// Log queues a line with alternating key/value fields, e.g. Log(LevelInfo, "started", "port", 8080)
+func (l *Logger) Log(level Level, msg string, kv ...interface{}) {
+	c := l.core
+	if level < c.opts.Level {
+		return
+	}
+	if level < LevelWarn && c.opts.SampleEvery > 1 && (atomic.AddUint64(&c.sampled, 1)-1)%uint64(c.opts.SampleEvery) != 0 {
+		return
+	}
+
+	var fields map[string]interface{}
+	if len(l.fields) > 0 || len(kv) > 0 {
+		fields = make(map[string]interface{}, len(l.fields)+len(kv)/2)
+		for k, v := range l.fields {
+			fields[k] = v
+		}
+		addFields(fields, kv)
+	}
+
+	e := LogEntry{
+		App:       c.r.service,
+		Hostname:  c.r.hostname,
+		Version:   c.r.version,
+		Level:     level,
+		Timestamp: time.Now().Unix(),
+		Msg:       msg,
+		Fields:    fields,
+	}
+
+	select {
+	case <-c.doneCh:
+		atomic.AddUint64(&c.dropped, 1)
+	default:
+		select {
+		case c.ch <- e:
+		default:
+			atomic.AddUint64(&c.dropped, 1)
+		}
+	}
+}
+
+// Dropped returns the number of lines dropped because the buffer was full, or the logger was closed
+func (l *Logger) Dropped() uint64 {
+	return atomic.LoadUint64(&l.core.dropped)
+}
+
+// Failed returns the number of lines that could not be produced
+func (l *Logger) Failed() uint64 {
+	return atomic.LoadUint64(&l.core.failed)
+}
+
+// Writer returns an io.Writer logging each write at level, e.g. for log.SetOutput
+func (l *Logger) Writer(level Level) io.Writer {
+	return &levelWriter{l: l, level: level}
+}
+
+// Close sends the queued lines and stops the logger, lines logged afterwards are dropped
+func (l *Logger) Close() {
+	c := l.core
+	c.closeOnce.Do(func() {
+		close(c.doneCh)
+		c.wg.Wait()
+	})
+}
+
+func (c *loggerCore) run() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]LogEntry, 0, c.opts.BatchSize)
+	for {
+		select {
+		case e := <-c.ch:
+			batch = append(batch, e)
+			if len(batch) >= c.opts.BatchSize {
+				batch = c.send(batch)
+			}
+		case <-ticker.C:
+			batch = c.send(batch)
+		case <-c.doneCh:
+			for {
+				select {
+				case e := <-c.ch:
+					batch = append(batch, e)
+				default:
+					c.send(batch)
+					c.reportFailures(true)
+					return
+				}
+			}
+		}
+	}
+}
+
+// send produces the batch, returning it emptied for reuse. Lines are produced without Reporter.produce,
+// whose errors go to Errfn for every message, and could loop when Errfn logs.
+func (c *loggerCore) send(batch []LogEntry) []LogEntry {
+	fullTopic := c.r.sc.FullTopic(c.topic)
+	for _, e := range batch {
+		err := errors.New("producer not set")
+		if c.r.sc.GetProducer() != nil { // producer can be nil if Initialize has errors
+			err = c.r.sc.Produce(&fullTopic, safeMarshal(e))
+		}
+		if err != nil {
+			atomic.AddUint64(&c.failed, 1)
+			c.unreported++
+			c.lastErr = err
+		}
+	}
+	c.reportFailures(false)
+	return batch[:0]
+}
+
+// reportFailures reports the produce failures since the last report to Errfn, once ErrorInterval
+// has passed or if forced
+func (c *loggerCore) reportFailures(force bool) {
+	now := time.Now()
+	if c.unreported == 0 || (!force && now.Sub(c.lastReport) < c.opts.ErrorInterval) {
+		return
+	}
+	c.r.errorHandler("log produce error", fmt.Errorf("%d lines not produced to %s, last error: %v",
+		c.unreported, c.r.sc.FullTopic(c.topic), c.lastErr))
+	c.unreported, c.lastErr, c.lastReport = 0, nil, now
+}
+
+// addFields adds alternating key/value pairs to fields, a trailing key without a value is kept under "!BADKEY"
+func addFields(fields map[string]interface{}, kv []interface{}) {
+	for i := 0; i < len(kv); i += 2 {
+		if i+1 == len(kv) {
+			fields["!BADKEY"] = kv[i]
+			break
+		}
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+		fields[key] = kv[i+1]
+	}
+}
+
+type levelWriter struct {
+	l     *Logger
+	level Level
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	w.l.Log(w.level, strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
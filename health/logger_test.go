@@ -0,0 +1,139 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// logReporter returns a reporter whose produced log lines are sent to the returned channel
+func logReporter() (*Reporter, chan map[string]interface{}) {
+	lines := make(chan map[string]interface{}, 100)
+	r, _ := mockReporter(func(_ string, value []byte) error {
+		var line map[string]interface{}
+		if err := json.Unmarshal(value, &line); err == nil {
+			lines <- line
+		}
+		return nil
+	})
+	return r, lines
+}
+
+func TestLevel(t *testing.T) {
+	var l Level
+	assert.NoError(t, l.Set("WARN"))
+	assert.Equal(t, LevelWarn, l)
+	assert.Error(t, l.Set("loud"))
+	assert.Equal(t, "error", LevelError.String())
+	assert.Equal(t, "level(9)", Level(9).String())
+}
+
+func TestLogger(t *testing.T) {
+	r, lines := logReporter()
+	l := r.NewLogger(LoggerOptions{Level: LevelInfo, FlushInterval: time.Hour})
+
+	l.Debug("hidden")
+	l.With("req", "abc").Info("started", "port", 8080, "dangling")
+	l.Error("failed")
+	l.Close()
+	l.Warn("after close")
+
+	assert.Len(t, lines, 2)
+	line := <-lines
+	assert.Equal(t, "test", line["app"])
+	assert.Equal(t, "info", line["level"])
+	assert.Equal(t, "started", line["msg"])
+	assert.Equal(t, r.hostname, line["hostname"])
+	assert.NotZero(t, line["timestamp"])
+	assert.Equal(t, map[string]interface{}{"req": "abc", "port": 8080.0, "!BADKEY": "dangling"}, line["fields"])
+	line = <-lines
+	assert.Equal(t, "error", line["level"])
+	assert.Nil(t, line["fields"])
+
+	assert.Equal(t, uint64(1), l.Dropped())
+}
+
+func TestLoggerBatchAndFlush(t *testing.T) {
+	r, lines := logReporter()
+	l := r.NewLogger(LoggerOptions{BatchSize: 2, FlushInterval: time.Millisecond * 20})
+	defer l.Close()
+
+	l.Info("one")
+	l.Info("two")
+	assert.Eventually(t, func() bool { return len(lines) == 2 }, time.Second, time.Millisecond)
+	l.Info("three")
+	assert.Eventually(t, func() bool { return len(lines) == 3 }, time.Second, time.Millisecond*5)
+
+	// the standard logger can be redirected
+	lg := log.New(l.Writer(LevelWarn), "", 0)
+	lg.Println("from log")
+	assert.Eventually(t, func() bool { return len(lines) == 4 }, time.Second, time.Millisecond*5)
+	for i := 0; i < 3; i++ {
+		<-lines
+	}
+	line := <-lines
+	assert.Equal(t, "from log", line["msg"])
+	assert.Equal(t, "warn", line["level"])
+}
+
+func TestLoggerSampling(t *testing.T) {
+	r, lines := logReporter()
+	l := r.NewLogger(LoggerOptions{SampleEvery: 3, FlushInterval: time.Hour})
+	for i := 0; i < 9; i++ {
+		l.Debug(fmt.Sprint(i))
+	}
+	l.Error("always")
+	l.Close()
+	assert.Len(t, lines, 4)
+	assert.Equal(t, "0", (<-lines)["msg"])
+	assert.Equal(t, "3", (<-lines)["msg"])
+}
+
+func TestLoggerDrops(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	core := &loggerCore{r: r, opts: LoggerOptions{}, ch: make(chan LogEntry, 2), doneCh: make(chan struct{})}
+	l := &Logger{core: core} // not running, so the buffer fills up
+	for i := 0; i < 5; i++ {
+		l.Info("line")
+	}
+	assert.Equal(t, uint64(3), l.Dropped())
+}
+
+func TestLogEntryJson(t *testing.T) {
+	b, err := json.Marshal(LogEntry{App: "a", Level: LevelWarn, Msg: "m"})
+	assert.NoError(t, err)
+	var e LogEntry
+	assert.NoError(t, json.Unmarshal(b, &e))
+	assert.Equal(t, LevelWarn, e.Level)
+	assert.Error(t, json.Unmarshal([]byte(`{"level":"loud"}`), &e))
+}
+
+func TestLoggerProduceFailures(t *testing.T) {
+	r, _ := mockReporter(func(string, []byte) error { return fmt.Errorf("broker down") })
+
+	var l *Logger
+	errs := make(chan error, 100)
+	r.Errfn = func(err error) {
+		errs <- err
+		l.Error(err.Error()) // fails too, but doesn't loop
+	}
+	l = r.NewLogger(LoggerOptions{BatchSize: 1, FlushInterval: time.Hour, ErrorInterval: time.Hour})
+	l.Info("one")
+	assert.Eventually(t, func() bool { return len(errs) == 1 }, time.Second, time.Millisecond)
+	l.Info("two")
+	l.Info("three")
+	assert.Eventually(t, func() bool { return l.Failed() == 4 }, time.Second, time.Millisecond)
+	time.Sleep(time.Millisecond * 20)
+	assert.Len(t, errs, 1) // rate limited
+
+	l.Close()
+	assert.Eventually(t, func() bool { return len(errs) == 2 }, time.Second, time.Millisecond)
+	assert.EqualError(t, <-errs, "log produce error: 1 lines not produced to test.test.log, last error: broker down")
+	assert.EqualError(t, <-errs, "log produce error: 3 lines not produced to test.test.log, last error: broker down")
+	time.Sleep(time.Millisecond * 20)
+	assert.Len(t, errs, 0)
+}
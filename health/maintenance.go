@@ -0,0 +1,295 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atsu/goat/util"
+)
+
+// MaintenanceDataKey is the Event.Data key holding the active MaintenanceStatus
+const MaintenanceDataKey = "maintenance"
+
+// MaintenanceWindow forces the reported state to Gray from Start until End
+type MaintenanceWindow struct {
+	Reason string
+	Start  time.Time
+	End    time.Time
+}
+
+// MaintenanceStatus is the active window as reported in Event.Data
+type MaintenanceStatus struct {
+	Reason   string `json:"reason"`
+	Start    int64  `json:"start"` // unix timestamp
+	End      int64  `json:"end"`   // unix timestamp
+	Schedule string `json:"schedule,omitempty"`
+}
+
+type maintenanceSchedule struct {
+	spec     string
+	cron     *cronSchedule
+	duration time.Duration
+	reason   string
+}
+
+type maintenance struct {
+	mux       sync.Mutex
+	windows   []MaintenanceWindow
+	schedules []*maintenanceSchedule
+	endedAt   time.Time // scheduled windows starting before this were ended early
+}
+
+// StartMaintenance starts a maintenance window now, lasting d
+func (r *Reporter) StartMaintenance(reason string, d time.Duration) {
+	now := time.Now()
+	r.ScheduleMaintenance(MaintenanceWindow{Reason: reason, Start: now, End: now.Add(d)})
+}
+
+// ScheduleMaintenance adds a maintenance window, which may be in the future
+func (r *Reporter) ScheduleMaintenance(w MaintenanceWindow) {
+	m := &r.maintenance
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.windows = append(m.windows, w)
+}
+
+// AddMaintenanceSchedule adds recurring maintenance windows lasting d, starting at the times matched by
+// a cron spec `minute hour day-of-month month day-of-week` in local time, e.g. `0 3 * * 0` is Sundays at 03:00.
+func (r *Reporter) AddMaintenanceSchedule(spec string, d time.Duration, reason string) error {
+	if d <= 0 {
+		return fmt.Errorf("maintenance duration must be positive")
+	}
+	cron, err := parseCron(spec)
+	if err != nil {
+		return err
+	}
+	m := &r.maintenance
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.schedules = append(m.schedules, &maintenanceSchedule{spec: spec, cron: cron, duration: d, reason: reason})
+	return nil
+}
+
+// ClearMaintenanceSchedules removes all recurring maintenance windows
+func (r *Reporter) ClearMaintenanceSchedules() {
+	m := &r.maintenance
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.schedules = nil
+}
+
+// EndMaintenance ends any active maintenance window now, including a scheduled one.
+// Windows starting in the future are kept.
+func (r *Reporter) EndMaintenance() {
+	m := &r.maintenance
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	now := time.Now()
+	kept := m.windows[:0]
+	for _, w := range m.windows {
+		if w.Start.After(now) {
+			kept = append(kept, w)
+		}
+	}
+	m.windows = kept
+	m.endedAt = now
+}
+
+// Maintenance returns the active maintenance window, if any
+func (r *Reporter) Maintenance() (MaintenanceStatus, bool) {
+	return r.maintenance.active(time.Now())
+}
+
+func (m *maintenance) active(now time.Time) (MaintenanceStatus, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	kept := m.windows[:0]
+	var status MaintenanceStatus
+	found := false
+	for _, w := range m.windows {
+		if !now.Before(w.End) {
+			continue // over
+		}
+		kept = append(kept, w)
+		if !found && !now.Before(w.Start) {
+			status = MaintenanceStatus{Reason: w.Reason, Start: w.Start.Unix(), End: w.End.Unix()}
+			found = true
+		}
+	}
+	m.windows = kept
+	if found {
+		return status, true
+	}
+
+	for _, s := range m.schedules {
+		if start, ok := s.cron.lastStart(now, s.duration); ok && start.After(m.endedAt) {
+			return MaintenanceStatus{Reason: s.reason, Start: start.Unix(), End: start.Add(s.duration).Unix(), Schedule: s.spec}, true
+		}
+	}
+	return MaintenanceStatus{}, false
+}
+
+// MaintenanceHandler is an admin endpoint for maintenance windows.
+// GET returns the active window (404 if none), POST starts one with the `reason` and `duration` (e.g. 30m)
+// query parameters, and DELETE ends the active window.
+func (r *Reporter) MaintenanceHandler(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		q := req.URL.Query()
+		d, err := time.ParseDuration(q.Get("duration"))
+		if err != nil || d <= 0 {
+			http.Error(w, "duration must be a positive duration, e.g. 30m", http.StatusBadRequest)
+			return
+		}
+		r.StartMaintenance(q.Get("reason"), d)
+	case http.MethodDelete:
+		r.EndMaintenance()
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, ok := r.Maintenance()
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_, err := w.Write([]byte("{}"))
+		r.errorHandler("could not write maintenance response", err)
+		return
+	}
+	_, err := w.Write(util.MarshalWithPretty(req, status))
+	r.errorHandler("could not write maintenance response", err)
+}
+
+// cronSchedule matches times against a 5 field cron spec
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	domAny, dowAny                bool
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7}, // 0 and 7 are Sunday
+}
+
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron spec %q must have %d fields", spec, len(cronFields))
+	}
+	sets := make([]map[int]bool, len(fields))
+	for i, f := range fields {
+		set, err := parseCronField(f, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("cron spec %q %s: %v", spec, cronFields[i].name, err)
+		}
+		sets[i] = set
+	}
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+	return &cronSchedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField parses a comma separated list of *, n, a-b, each optionally with a /step
+func parseCronField(f string, min, max int) (map[int]bool, error) {
+	set := make(map[int]bool)
+	for _, part := range strings.Split(f, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s < 1 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			step = s
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			v, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			lo, hi = v, v
+			if step > 1 { // n/step means from n to max
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func (c *cronSchedule) matches(t time.Time) bool {
+	return c.minute[t.Minute()] && c.hour[t.Hour()] && c.matchesDay(t)
+}
+
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	if !c.month[int(t.Month())] {
+		return false
+	}
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow // like cron, when both are restricted either may match
+}
+
+// lastStart returns the latest start within (now-d, now], if any.
+// Days and hours that don't match are skipped whole, so only the minutes of matching hours are checked.
+func (c *cronSchedule) lastStart(now time.Time, d time.Duration) (time.Time, bool) {
+	t := now.Truncate(time.Minute)
+	for now.Sub(t) < d {
+		var prev time.Time
+		switch {
+		case !c.matchesDay(t):
+			prev = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		case !c.hour[t.Hour()]:
+			prev = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+		case c.minute[t.Minute()]:
+			return t, true
+		}
+		if prev.IsZero() || !prev.Before(t) { // same as t, or moved by a time zone change
+			prev = t
+		}
+		t = prev.Add(-time.Minute)
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,187 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReporterMaintenance(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	r.SetHealth(Green, "ok")
+
+	_, ok := r.Maintenance()
+	assert.False(t, ok)
+
+	r.StartMaintenance("upgrading db", time.Hour)
+	h := r.Health()
+	assert.Equal(t, Gray, h.State)
+	assert.Equal(t, "upgrading db", h.Message)
+	status := h.Data.(map[string]interface{})[MaintenanceDataKey].(MaintenanceStatus)
+	assert.Equal(t, "upgrading db", status.Reason)
+	assert.InDelta(t, time.Now().Add(time.Hour).Unix(), status.End, 1)
+
+	// the state set during maintenance is restored afterwards
+	r.SetHealth(Yellow, "slow")
+	assert.Equal(t, Gray, r.Health().State)
+	r.EndMaintenance()
+	h = r.Health()
+	assert.Equal(t, Yellow, h.State)
+	assert.Equal(t, "slow", h.Message)
+
+	// future windows wait, expired windows are dropped
+	now := time.Now()
+	r.ScheduleMaintenance(MaintenanceWindow{Reason: "later", Start: now.Add(time.Hour), End: now.Add(time.Hour * 2)})
+	r.ScheduleMaintenance(MaintenanceWindow{Reason: "past", Start: now.Add(-time.Hour * 2), End: now.Add(-time.Hour)})
+	assert.Equal(t, Yellow, r.Health().State)
+	r.EndMaintenance() // keeps the future window
+	_, ok = r.maintenance.active(now.Add(time.Hour + time.Minute))
+	assert.True(t, ok)
+	assert.Len(t, r.maintenance.windows, 1)
+}
+
+func TestMaintenanceSchedule(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	assert.Error(t, r.AddMaintenanceSchedule("0 3 * *", time.Hour, "nightly"))
+	assert.Error(t, r.AddMaintenanceSchedule("0 3 * * *", 0, "nightly"))
+	assert.NoError(t, r.AddMaintenanceSchedule("0 3 * * 0", time.Hour, "weekly backup"))
+
+	m := &r.maintenance
+	sunday := time.Date(2021, 5, 2, 3, 0, 0, 0, time.UTC)
+	_, ok := m.active(sunday.Add(-time.Second))
+	assert.False(t, ok)
+	status, ok := m.active(sunday.Add(time.Minute * 30))
+	assert.True(t, ok)
+	assert.Equal(t, MaintenanceStatus{Reason: "weekly backup", Start: sunday.Unix(), End: sunday.Add(time.Hour).Unix(), Schedule: "0 3 * * 0"}, status)
+	_, ok = m.active(sunday.Add(time.Hour))
+	assert.False(t, ok)
+	_, ok = m.active(sunday.Add(time.Hour * 24).Add(time.Minute)) // monday
+	assert.False(t, ok)
+
+	// ending a scheduled window early only skips the current occurrence
+	m.endedAt = sunday.Add(time.Minute * 10)
+	_, ok = m.active(sunday.Add(time.Minute * 30))
+	assert.False(t, ok)
+	_, ok = m.active(sunday.Add(time.Hour * 24 * 7).Add(time.Minute))
+	assert.True(t, ok)
+
+	r.ClearMaintenanceSchedules()
+	_, ok = m.active(sunday.Add(time.Hour * 24 * 7).Add(time.Minute))
+	assert.False(t, ok)
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		spec  string
+		match []time.Time
+		miss  []time.Time
+	}{
+		{"*/15 * * * *",
+			[]time.Time{time.Date(2021, 1, 1, 5, 45, 0, 0, time.UTC)},
+			[]time.Time{time.Date(2021, 1, 1, 5, 46, 0, 0, time.UTC)}},
+		{"30 1-3,22 * * *",
+			[]time.Time{time.Date(2021, 1, 1, 2, 30, 0, 0, time.UTC), time.Date(2021, 1, 1, 22, 30, 0, 0, time.UTC)},
+			[]time.Time{time.Date(2021, 1, 1, 4, 30, 0, 0, time.UTC)}},
+		{"0 0 1 * 7", // the 1st, or any sunday
+			[]time.Time{time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 6, 6, 0, 0, 0, 0, time.UTC)},
+			[]time.Time{time.Date(2021, 6, 2, 0, 0, 0, 0, time.UTC)}},
+		{"0 0 * 12 *",
+			[]time.Time{time.Date(2021, 12, 24, 0, 0, 0, 0, time.UTC)},
+			[]time.Time{time.Date(2021, 11, 24, 0, 0, 0, 0, time.UTC)}},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			c, err := parseCron(test.spec)
+			assert.NoError(t, err)
+			for _, m := range test.match {
+				assert.True(t, c.matches(m), m.String())
+			}
+			for _, m := range test.miss {
+				assert.False(t, c.matches(m), m.String())
+			}
+		})
+	}
+
+	for _, bad := range []string{"60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *", "* * * * * *"} {
+		_, err := parseCron(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestCronLastStart(t *testing.T) {
+	// the latest start by checking every minute
+	scan := func(c *cronSchedule, now time.Time, d time.Duration) (time.Time, bool) {
+		for t := now.Truncate(time.Minute); now.Sub(t) < d; t = t.Add(-time.Minute) {
+			if c.matches(t) {
+				return t, true
+			}
+		}
+		return time.Time{}, false
+	}
+	kolkata := time.FixedZone("IST", 5*3600+1800)
+	now := time.Date(2021, 6, 9, 14, 7, 30, 0, kolkata)
+	for _, spec := range []string{"0 3 * * 0", "*/15 * * * *", "30 1-3,22 * * *", "0 0 1 * 7", "0 0 * 12 *", "59 23 31 * *"} {
+		c, err := parseCron(spec)
+		assert.NoError(t, err)
+		for _, d := range []time.Duration{time.Minute, time.Hour, time.Hour * 25, time.Hour * 24 * 45, time.Hour * 24 * 400} {
+			for _, at := range []time.Time{now, now.In(time.UTC), now.Add(time.Hour * 24 * 100)} {
+				want, wantOk := scan(c, at, d)
+				got, ok := c.lastStart(at, d)
+				assert.Equal(t, wantOk, ok, "%s %s %s", spec, d, at)
+				assert.True(t, want.Equal(got), "%s %s %s: %s != %s", spec, d, at, got, want)
+			}
+		}
+	}
+}
+
+func TestMaintenanceHandler(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+
+	rec := httptest.NewRecorder()
+	r.MaintenanceHandler(rec, httptest.NewRequest("GET", "/maintenance", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	r.MaintenanceHandler(rec, httptest.NewRequest("POST", "/maintenance?reason=deploy&duration=bad", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	r.MaintenanceHandler(rec, httptest.NewRequest("POST", "/maintenance?reason=deploy&duration=10m", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var status MaintenanceStatus
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(t, "deploy", status.Reason)
+	assert.Equal(t, Gray, r.Health().State)
+
+	rec = httptest.NewRecorder()
+	r.MaintenanceHandler(rec, httptest.NewRequest("DELETE", "/maintenance", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, StateDefault, r.Health().State)
+
+	rec = httptest.NewRecorder()
+	r.MaintenanceHandler(rec, httptest.NewRequest("PUT", "/maintenance", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestMaintenanceNotDamped(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	r.RemoveSink(KafkaSinkName)
+	r.SetDamping(&DampingPolicy{WorsenReports: 5, RecoverReports: 5})
+	r.SetHealth(Green, "ok")
+	r.ReportHealth()
+
+	r.StartMaintenance("deploy", time.Hour)
+	r.ReportHealth()
+	r.EndMaintenance()
+	r.ReportHealth()
+
+	history := r.Transitions()
+	assert.Len(t, history, 3)
+	assert.Equal(t, Gray, history[1].To)
+	assert.Equal(t, "deploy", history[1].Reason)
+	assert.Equal(t, Green, history[2].To)
+}
@@ -0,0 +1,228 @@
+package health
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets are the histogram upper bounds used when none are given, suited to latencies in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric is implemented by all typed metrics registered on a Reporter
+type metric interface {
+	snapshot() interface{} // the value reported in Event.Data
+	reset()
+}
+
+// Counter is a monotonically increasing count, safe for concurrent use
+type Counter struct {
+	v int64
+}
+
+func (c *Counter) Inc() {
+	atomic.AddInt64(&c.v, 1)
+}
+
+// Add n to the counter, negative values are ignored
+func (c *Counter) Add(n int64) {
+	if n > 0 {
+		atomic.AddInt64(&c.v, n)
+	}
+}
+
+func (c *Counter) Value() int64 {
+	return atomic.LoadInt64(&c.v)
+}
+
+func (c *Counter) snapshot() interface{} { return c.Value() }
+func (c *Counter) reset()                { atomic.StoreInt64(&c.v, 0) }
+
+// Gauge is a value that can go up and down, safe for concurrent use
+type Gauge struct {
+	bits uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		nv := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&g.bits, old, nv) {
+			return
+		}
+	}
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+func (g *Gauge) snapshot() interface{} { return g.Value() }
+func (g *Gauge) reset()                { g.Set(0) }
+
+// HistogramSnapshot is how a Histogram is reported, Buckets are cumulative counts keyed by upper bound
+type HistogramSnapshot struct {
+	Count   uint64            `json:"count"`
+	Sum     float64           `json:"sum"`
+	Buckets map[string]uint64 `json:"buckets"`
+}
+
+// Histogram counts observations into configurable buckets, safe for concurrent use
+type Histogram struct {
+	mux    sync.Mutex
+	bounds []float64 // sorted upper bounds, +Inf is implicit
+	counts []uint64  // per bucket (not cumulative), len(bounds)+1
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram creates an unregistered histogram, nil buckets means DefaultBuckets
+func NewHistogram(buckets []float64) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v) // first bound >= v
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.counts[i]++
+	h.count++
+	h.sum += v
+}
+
+// Snapshot returns the current cumulative counts
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	s := HistogramSnapshot{Count: h.count, Sum: h.sum, Buckets: make(map[string]uint64, len(h.counts))}
+	var cumulative uint64
+	for i, c := range h.counts {
+		cumulative += c
+		s.Buckets[bucketLabel(h.bounds, i)] = cumulative
+	}
+	return s
+}
+
+// bucketLabel returns the upper bound of bucket i as reported, e.g. "0.25" or "+Inf"
+func bucketLabel(bounds []float64, i int) string {
+	if i == len(bounds) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(bounds[i], 'g', -1, 64)
+}
+
+func (h *Histogram) snapshot() interface{} { return h.Snapshot() }
+
+func (h *Histogram) reset() {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.counts = make([]uint64, len(h.bounds)+1)
+	h.count = 0
+	h.sum = 0
+}
+
+// Timer is a Histogram of durations, observed in seconds
+type Timer struct {
+	h *Histogram
+}
+
+// NewTimer creates an unregistered timer, nil buckets means DefaultBuckets
+func NewTimer(buckets []float64) *Timer {
+	return &Timer{h: NewHistogram(buckets)}
+}
+
+func (t *Timer) Observe(d time.Duration) {
+	t.h.Observe(d.Seconds())
+}
+
+// Since observes the time elapsed since start, e.g. defer t.Since(time.Now())
+func (t *Timer) Since(start time.Time) {
+	t.Observe(time.Since(start))
+}
+
+// Time observes how long fn takes
+func (t *Timer) Time(fn func()) {
+	defer t.Since(time.Now())
+	fn()
+}
+
+func (t *Timer) Snapshot() HistogramSnapshot {
+	return t.h.Snapshot()
+}
+
+func (t *Timer) snapshot() interface{} { return t.h.Snapshot() }
+func (t *Timer) reset()                { t.h.reset() }
+
+// metricKind is the type of a registered metric, compared instead of creating a metric on every lookup
+type metricKind int
+
+const (
+	counterKind metricKind = iota
+	gaugeKind
+	histogramKind
+	timerKind
+)
+
+// registeredMetric is a metric along with its kind, as stored in Reporter.metrics
+type registeredMetric struct {
+	kind metricKind
+	m    metric
+}
+
+// Counter returns the counter registered as name, registering a new one if needed
+func (r *Reporter) Counter(name string) *Counter {
+	return r.registerMetric(name, counterKind, func() metric { return &Counter{} }).(*Counter)
+}
+
+// Gauge returns the gauge registered as name, registering a new one if needed
+func (r *Reporter) Gauge(name string) *Gauge {
+	return r.registerMetric(name, gaugeKind, func() metric { return &Gauge{} }).(*Gauge)
+}
+
+// Histogram returns the histogram registered as name, registering a new one if needed.
+// buckets are only used when registering, nil means DefaultBuckets
+func (r *Reporter) Histogram(name string, buckets []float64) *Histogram {
+	return r.registerMetric(name, histogramKind, func() metric { return NewHistogram(buckets) }).(*Histogram)
+}
+
+// Timer returns the timer registered as name, registering a new one if needed.
+// buckets (in seconds) are only used when registering, nil means DefaultBuckets
+func (r *Reporter) Timer(name string, buckets []float64) *Timer {
+	return r.registerMetric(name, timerKind, func() metric { return NewTimer(buckets) }).(*Timer)
+}
+
+// registerMetric returns the metric registered as name, or registers the result of create.
+// If name is registered as a different kind the caller gets an unregistered metric.
+func (r *Reporter) registerMetric(name string, kind metricKind, create func() metric) metric {
+	v, ok := r.metrics.Load(name)
+	if !ok {
+		v, _ = r.metrics.LoadOrStore(name, &registeredMetric{kind: kind, m: create()})
+	}
+	rm := v.(*registeredMetric)
+	if rm.kind != kind {
+		fmt.Printf("[warn] metric %s is already registered as %T and will not be reported.\n", name, rm.m)
+		return create()
+	}
+	return rm.m
+}
+
+// resetMetrics zeroes all registered metrics, they stay registered
+func (r *Reporter) resetMetrics() {
+	r.metrics.Range(func(_, rm interface{}) bool {
+		rm.(*registeredMetric).m.reset()
+		return true
+	})
+}
@@ -0,0 +1,153 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounter(t *testing.T) {
+	var c Counter
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Inc()
+			c.Add(2)
+			c.Add(-5) // ignored
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(300), c.Value())
+}
+
+func TestGauge(t *testing.T) {
+	var g Gauge
+	g.Set(1.5)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Add(1)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 101.5, g.Value())
+	g.Add(-200)
+	assert.Equal(t, -98.5, g.Value())
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]float64{10, 1, 5})
+	for _, v := range []float64{0.5, 1, 3, 7, 100} {
+		h.Observe(v)
+	}
+	s := h.Snapshot()
+	assert.Equal(t, uint64(5), s.Count)
+	assert.Equal(t, 111.5, s.Sum)
+	assert.Equal(t, map[string]uint64{"1": 2, "5": 3, "10": 4, "+Inf": 5}, s.Buckets)
+
+	h.reset()
+	s = h.Snapshot()
+	assert.Zero(t, s.Count)
+	assert.Zero(t, s.Sum)
+	assert.Equal(t, uint64(0), s.Buckets["+Inf"])
+
+	assert.Len(t, NewHistogram(nil).Snapshot().Buckets, len(DefaultBuckets)+1)
+}
+
+func TestTimer(t *testing.T) {
+	tm := NewTimer([]float64{0.1, 1})
+	tm.Observe(50 * time.Millisecond)
+	tm.Time(func() {})
+	tm.Since(time.Now().Add(-2 * time.Second))
+
+	s := tm.Snapshot()
+	assert.Equal(t, uint64(3), s.Count)
+	assert.Equal(t, uint64(2), s.Buckets["0.1"])
+	assert.Equal(t, uint64(2), s.Buckets["1"])
+	assert.Equal(t, uint64(3), s.Buckets["+Inf"])
+	assert.True(t, s.Sum > 2)
+}
+
+func TestReporterMetrics(t *testing.T) {
+	r := NewReporter("test", "test", "test", func(error) {})
+
+	r.Counter("requests").Add(3)
+	r.Counter("requests").Inc() // same counter
+	r.Gauge("queue").Set(7)
+	r.Histogram("size", []float64{1}).Observe(2)
+	r.Timer("latency", nil).Observe(time.Second)
+	r.AddStat("plain", "stat")
+
+	// a name registered as another type is not reported
+	r.Gauge("requests").Set(100)
+	assert.Equal(t, int64(4), r.Counter("requests").Value())
+
+	// lookups compare the registered kind without creating a metric
+	created := 0
+	create := func() metric { created++; return &Counter{} }
+	r.registerMetric("requests", counterKind, create)
+	assert.Equal(t, 0, created)
+	r.registerMetric("queue", counterKind, create)
+	assert.Equal(t, 1, created)
+
+	data := r.Health().Data.(map[string]interface{})
+	assert.Equal(t, int64(4), data["requests"])
+	assert.Equal(t, float64(7), data["queue"])
+	assert.Equal(t, uint64(1), data["size"].(HistogramSnapshot).Buckets["+Inf"])
+	assert.Equal(t, uint64(1), data["latency"].(HistogramSnapshot).Count)
+	assert.Equal(t, "stat", data["plain"])
+
+	// the event still marshals
+	assert.Contains(t, string(r.JsonStats()), `"size":{"count":1,"sum":2,"buckets":{"+Inf":1,"1":0}}`)
+
+	// ClearStats resets, but keeps, the metrics
+	c := r.Counter("requests")
+	r.ClearStats()
+	data = r.Health().Data.(map[string]interface{})
+	assert.Equal(t, int64(0), data["requests"])
+	assert.Equal(t, float64(0), data["queue"])
+	assert.Nil(t, data["plain"])
+	c.Inc()
+	assert.Equal(t, int64(1), r.Counter("requests").Value())
+
+	assert.NoError(t, r.Stop())
+}
+
+func TestMetricsHandlerTyped(t *testing.T) {
+	r := NewReporter("svc", "test", "test", func(error) {})
+	r.hostname = "h"
+	r.Counter("hits").Add(2)
+	r.Gauge("temp").Set(0.5)
+	r.Timer("wait", []float64{1}).Observe(2 * time.Second)
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	http.HandlerFunc(r.MetricsHandler).ServeHTTP(rr, req)
+	body := rr.Body.String()
+
+	for _, line := range []string{
+		"# TYPE svc_hits counter",
+		`svc_hits{service="svc",host="h"} 2`,
+		"# TYPE svc_temp gauge",
+		`svc_temp{service="svc",host="h"} 0.5`,
+		"# TYPE svc_wait histogram",
+		`svc_wait_bucket{service="svc",host="h",le="1"} 0`,
+		`svc_wait_bucket{service="svc",host="h",le="+Inf"} 1`,
+		`svc_wait_sum{service="svc",host="h"} 2`,
+		`svc_wait_count{service="svc",host="h"} 1`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+	// not also rendered as a flattened stat
+	assert.NotContains(t, body, "svc_wait_buckets")
+
+	assert.NoError(t, r.Stop())
+}
@@ -0,0 +1,162 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/atsu/goat/util"
+)
+
+// ProbeConfig controls the liveness and readiness handlers
+type ProbeConfig struct {
+	LiveStates   []State       // states considered live, see DefaultLiveStates
+	ReadyStates  []State       // states considered ready, see DefaultReadyStates
+	RequireKafka bool          // when true, readiness also requires kafka to be healthy
+	StartupGrace time.Duration // liveness always passes for this long after the reporter is created
+}
+
+var DefaultLiveStates = []State{Blue, Green, Yellow, Gray}
+var DefaultReadyStates = []State{Green, Yellow}
+
+// DefaultProbeConfig is used until SetProbeConfig is called
+func DefaultProbeConfig() ProbeConfig {
+	return ProbeConfig{LiveStates: DefaultLiveStates, ReadyStates: DefaultReadyStates}
+}
+
+// ProbeFailure is a reason a probe failed, as listed by a verbose probe
+type ProbeFailure struct {
+	Name    string `json:"name"`
+	State   State  `json:"state,omitempty"`
+	Message string `json:"msg"`
+}
+
+// ProbeResult is the verbose probe response
+type ProbeResult struct {
+	Ok      bool           `json:"ok"`
+	State   State          `json:"state"`
+	Message string         `json:"msg"`
+	Failing []ProbeFailure `json:"failing,omitempty"`
+}
+
+// SetProbeConfig replaces the probe configuration, empty state lists are replaced by the defaults
+func (r *Reporter) SetProbeConfig(pc ProbeConfig) {
+	if len(pc.LiveStates) == 0 {
+		pc.LiveStates = DefaultLiveStates
+	}
+	if len(pc.ReadyStates) == 0 {
+		pc.ReadyStates = DefaultReadyStates
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.probes = pc
+}
+
+func (r *Reporter) probeConfig() ProbeConfig {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.probes
+}
+
+// Live returns the liveness of the reporter, see LivenessHandler
+func (r *Reporter) Live() ProbeResult {
+	pc := r.probeConfig()
+	h := r.dampedHealth()
+	res := ProbeResult{State: h.State, Message: h.Message}
+
+	if time.Since(r.created) < pc.StartupGrace {
+		res.Ok = true
+		return res
+	}
+
+	if !stateIn(h.State, pc.LiveStates) {
+		res.Failing = append(res.Failing, ProbeFailure{Name: "state", State: h.State, Message: h.Message})
+	}
+	res.Failing = append(res.Failing, failingChecks(r.CheckStatuses(), pc.LiveStates, false)...)
+	res.Ok = len(res.Failing) == 0
+	return res
+}
+
+// Ready returns the readiness of the reporter, see ReadinessHandler
+func (r *Reporter) Ready() ProbeResult {
+	pc := r.probeConfig()
+	h := r.dampedHealth()
+	res := ProbeResult{State: h.State, Message: h.Message}
+
+	if !stateIn(h.State, pc.ReadyStates) {
+		res.Failing = append(res.Failing, ProbeFailure{Name: "state", State: h.State, Message: h.Message})
+	}
+	res.Failing = append(res.Failing, failingChecks(r.CheckStatuses(), pc.ReadyStates, true)...)
+	if pc.RequireKafka {
+		if healthy, err := r.KafkaHealthy(); !healthy {
+			msg := "kafka not available"
+			if err != nil {
+				msg = err.Error()
+			}
+			res.Failing = append(res.Failing, ProbeFailure{Name: "kafka", Message: msg})
+		}
+	}
+	res.Ok = len(res.Failing) == 0
+	return res
+}
+
+// LivenessHandler responds 200 when live and 503 otherwise, for use as a kubernetes liveness probe.
+// Use the `verbose` query parameter to get the ProbeResult as json.
+func (r *Reporter) LivenessHandler(w http.ResponseWriter, req *http.Request) {
+	r.writeProbe(w, req, r.Live())
+}
+
+// ReadinessHandler responds 200 when ready and 503 otherwise, for use as a kubernetes readiness probe.
+// Use the `verbose` query parameter to get the ProbeResult as json.
+func (r *Reporter) ReadinessHandler(w http.ResponseWriter, req *http.Request) {
+	r.writeProbe(w, req, r.Ready())
+}
+
+func (r *Reporter) writeProbe(w http.ResponseWriter, req *http.Request, res ProbeResult) {
+	status := http.StatusOK
+	if !res.Ok {
+		status = http.StatusServiceUnavailable
+	}
+
+	var out []byte
+	if verbose, _ := strconv.ParseBool(req.URL.Query().Get("verbose")); verbose {
+		w.Header().Set("Content-Type", "application/json")
+		out = util.MarshalWithPretty(req, res)
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		out = []byte(fmt.Sprintf("%s\n", http.StatusText(status)))
+	}
+	w.WriteHeader(status)
+	_, err := w.Write(out)
+	r.errorHandler("could not write probe response", err)
+}
+
+// failingChecks returns the critical checks whose state is not in ok, sorted by name.
+// When pending is true, checks that have never run are also failing.
+func failingChecks(statuses map[string]CheckStatus, ok []State, pending bool) []ProbeFailure {
+	var failing []ProbeFailure
+	for name, s := range statuses {
+		switch {
+		case !s.Critical:
+		case s.LastRun == 0:
+			if pending {
+				failing = append(failing, ProbeFailure{Name: name, Message: "check has not run yet"})
+			}
+		case !stateIn(s.State, ok):
+			failing = append(failing, ProbeFailure{Name: name, State: s.State, Message: s.Message})
+		}
+	}
+	sort.Slice(failing, func(i, j int) bool { return failing[i].Name < failing[j].Name })
+	return failing
+}
+
+func stateIn(s State, states []State) bool {
+	for _, st := range states {
+		if s == st {
+			return true
+		}
+	}
+	return false
+}
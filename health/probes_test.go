@@ -0,0 +1,111 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func probe(t *testing.T, handler http.HandlerFunc, url string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr.Code, rr.Body.String()
+}
+
+func TestLivenessHandler(t *testing.T) {
+	r := NewReporter("test", "test", "test", func(error) {})
+
+	code, body := probe(t, r.LivenessHandler, "/livez")
+	assert.Equal(t, http.StatusOK, code) // Blue is live
+	assert.Equal(t, "OK\n", body)
+
+	r.SetHealth(Red, "broken")
+	code, body = probe(t, r.LivenessHandler, "/livez")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "Service Unavailable\n", body)
+
+	// within the startup grace period liveness always passes
+	r.SetProbeConfig(ProbeConfig{StartupGrace: time.Hour})
+	code, _ = probe(t, r.LivenessHandler, "/livez")
+	assert.Equal(t, http.StatusOK, code)
+
+	// red is configured as live
+	r.SetProbeConfig(ProbeConfig{LiveStates: []State{Red}})
+	code, _ = probe(t, r.LivenessHandler, "/livez")
+	assert.Equal(t, http.StatusOK, code)
+
+	assert.NoError(t, r.Stop())
+}
+
+func TestReadinessHandler(t *testing.T) {
+	r := NewReporter("test", "test", "test", func(error) {})
+
+	code, _ := probe(t, r.ReadinessHandler, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code) // Blue is not ready
+
+	r.SetHealth(Green, "ok")
+	code, _ = probe(t, r.ReadinessHandler, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+
+	// a critical check that hasn't run yet isn't ready, a non critical one doesn't matter
+	block := make(chan struct{})
+	r.RegisterCheck("db", func(context.Context) CheckResult { <-block; return CheckResult{State: Green} },
+		CheckOptions{Critical: true, Timeout: time.Hour})
+	r.RegisterCheck("cache", staticCheck(Red, "cold"), CheckOptions{})
+	code, body := probe(t, r.ReadinessHandler, "/readyz?verbose=true")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	var res ProbeResult
+	assert.NoError(t, json.Unmarshal([]byte(body), &res))
+	assert.False(t, res.Ok)
+	assert.Equal(t, []ProbeFailure{{Name: "db", Message: "check has not run yet"}}, res.Failing)
+	close(block)
+
+	assert.Eventually(t, func() bool { return r.Ready().Ok }, time.Second, time.Millisecond)
+
+	// kafka is never healthy without Initialize
+	r.SetProbeConfig(ProbeConfig{RequireKafka: true})
+	res = r.Ready()
+	assert.False(t, res.Ok)
+	assert.Equal(t, "kafka", res.Failing[0].Name)
+
+	assert.NoError(t, r.Stop())
+}
+
+func TestProbeVerboseFailingChecks(t *testing.T) {
+	r := NewReporter("test", "test", "test", func(error) {})
+	r.SetHealth(Green, "ok")
+	r.RegisterCheck("b", staticCheck(Red, "down"), CheckOptions{Critical: true})
+	r.RegisterCheck("a", staticCheck(Yellow, "slow"), CheckOptions{Critical: true})
+	r.RunChecks()
+
+	// yellow is live and ready by default, red is neither
+	code, body := probe(t, r.LivenessHandler, "/livez?verbose=1")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	var res ProbeResult
+	assert.NoError(t, json.Unmarshal([]byte(body), &res))
+	assert.Equal(t, Red, res.State)
+	assert.Equal(t, []ProbeFailure{
+		{Name: "state", State: Red, Message: "b: down"},
+		{Name: "b", State: Red, Message: "down"},
+	}, res.Failing)
+
+	r.SetProbeConfig(ProbeConfig{ReadyStates: []State{Green}, LiveStates: []State{Green, Yellow, Red}})
+	res = r.Ready()
+	assert.Len(t, res.Failing, 3)
+	assert.Equal(t, "a", res.Failing[1].Name)
+	assert.Equal(t, "b", res.Failing[2].Name)
+	assert.True(t, r.Live().Ok)
+
+	assert.NoError(t, r.Stop())
+}
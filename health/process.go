@@ -0,0 +1,174 @@
+package health
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+)
+
+// ProcessStatsKey is the stat key the ProcessCollector reports under
+const ProcessStatsKey = "process"
+
+// DefaultProcessInterval is the minimum time between two process samples
+const DefaultProcessInterval = time.Second * 10
+
+// ProcessField selects a group of process stats
+type ProcessField string
+
+const (
+	FieldGoroutines = ProcessField("goroutines")  // goroutines
+	FieldHeap       = ProcessField("heap")        // heap_alloc, heap_inuse, heap_sys, heap_objects (bytes, count)
+	FieldGC         = ProcessField("gc")          // gc_count, gc_pause_total_ms, gc_pause_last_ms
+	FieldRSS        = ProcessField("rss")         // rss, vms (bytes)
+	FieldCPU        = ProcessField("cpu_percent") // cpu_percent since the last sample
+	FieldFDs        = ProcessField("fds")         // fds, not available on windows
+	FieldThreads    = ProcessField("threads")     // threads
+	FieldUptime     = ProcessField("uptime")      // uptime (seconds)
+)
+
+// AllProcessFields is used when a ProcessCollector is created without fields
+var AllProcessFields = []ProcessField{FieldGoroutines, FieldHeap, FieldGC, FieldRSS, FieldCPU, FieldFDs, FieldThreads, FieldUptime}
+
+// ProcessCollector samples runtime and process stats of the current process
+type ProcessCollector struct {
+	interval time.Duration
+	fields   map[ProcessField]bool
+	proc     *process.Process
+	started  time.Time
+
+	mux     sync.Mutex
+	last    map[string]interface{}
+	lastErr error
+	sampled time.Time
+}
+
+// NewProcessCollector creates a collector sampling at most once per interval (DefaultProcessInterval if < 1),
+// reporting the given fields, or AllProcessFields if none are given.
+func NewProcessCollector(interval time.Duration, fields ...ProcessField) (*ProcessCollector, error) {
+	if interval <= 0 {
+		interval = DefaultProcessInterval
+	}
+	if len(fields) == 0 {
+		fields = AllProcessFields
+	}
+	proc, err := process.NewProcess(int32(os.Getpid()))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ProcessCollector{interval: interval, fields: make(map[ProcessField]bool), proc: proc, started: time.Now()}
+	for _, f := range fields {
+		c.fields[f] = true
+	}
+	if created, err := proc.CreateTime(); err == nil {
+		c.started = time.Unix(0, created*int64(time.Millisecond))
+	}
+	if c.fields[FieldCPU] {
+		_, _ = proc.Percent(0) // the first call only records the cpu times
+	}
+	return c, nil
+}
+
+// Register adds the process stats to every report of r, under ProcessStatsKey.
+// Sampling errors are passed to Errfn.
+func (c *ProcessCollector) Register(r *Reporter) {
+	r.RegisterStatFn(ProcessStatsKey, func(ir IReporter) {
+		stats, err := c.Collect()
+		r.errorHandler("could not collect process stats", err)
+		ir.AddStat(ProcessStatsKey, stats)
+	})
+}
+
+// Collect returns the latest sample, taking a new one if the last is older than the interval.
+// The stats that could be sampled are always returned, along with an error listing those that could not.
+func (c *ProcessCollector) Collect() (map[string]interface{}, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.last != nil && time.Since(c.sampled) < c.interval {
+		return copyStats(c.last), c.lastErr
+	}
+	c.last, c.lastErr = c.sample()
+	c.sampled = time.Now()
+	return copyStats(c.last), c.lastErr
+}
+
+func (c *ProcessCollector) sample() (map[string]interface{}, error) {
+	stats := make(map[string]interface{})
+	var errs []string
+	fail := func(f ProcessField, err error) {
+		errs = append(errs, fmt.Sprintf("%s: %v", f, err))
+	}
+
+	if c.fields[FieldGoroutines] {
+		stats["goroutines"] = runtime.NumGoroutine()
+	}
+	if c.fields[FieldHeap] || c.fields[FieldGC] {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		if c.fields[FieldHeap] {
+			stats["heap_alloc"] = ms.HeapAlloc
+			stats["heap_inuse"] = ms.HeapInuse
+			stats["heap_sys"] = ms.HeapSys
+			stats["heap_objects"] = ms.HeapObjects
+		}
+		if c.fields[FieldGC] {
+			stats["gc_count"] = ms.NumGC
+			stats["gc_pause_total_ms"] = float64(ms.PauseTotalNs) / float64(time.Millisecond)
+			var last uint64
+			if ms.NumGC > 0 {
+				last = ms.PauseNs[(ms.NumGC+255)%256]
+			}
+			stats["gc_pause_last_ms"] = float64(last) / float64(time.Millisecond)
+		}
+	}
+	if c.fields[FieldRSS] {
+		if mi, err := c.proc.MemoryInfo(); err != nil {
+			fail(FieldRSS, err)
+		} else {
+			stats["rss"] = mi.RSS
+			stats["vms"] = mi.VMS
+		}
+	}
+	if c.fields[FieldCPU] {
+		if pct, err := c.proc.Percent(0); err != nil {
+			fail(FieldCPU, err)
+		} else {
+			stats["cpu_percent"] = pct
+		}
+	}
+	if c.fields[FieldFDs] {
+		if n, err := c.proc.NumFDs(); err != nil {
+			fail(FieldFDs, err)
+		} else {
+			stats["fds"] = n
+		}
+	}
+	if c.fields[FieldThreads] {
+		if n, err := c.proc.NumThreads(); err != nil {
+			fail(FieldThreads, err)
+		} else {
+			stats["threads"] = n
+		}
+	}
+	if c.fields[FieldUptime] {
+		stats["uptime"] = int64(time.Since(c.started) / time.Second)
+	}
+
+	if len(errs) > 0 {
+		return stats, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return stats, nil
+}
+
+func copyStats(stats map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(stats))
+	for k, v := range stats {
+		out[k] = v
+	}
+	return out
+}
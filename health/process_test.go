@@ -0,0 +1,59 @@
+package health
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessCollector(t *testing.T) {
+	c, err := NewProcessCollector(time.Hour)
+	assert.NoError(t, err)
+
+	stats, err := c.Collect()
+	if runtime.GOOS == "linux" {
+		assert.NoError(t, err)
+	}
+	for _, k := range []string{"goroutines", "heap_alloc", "heap_inuse", "heap_sys", "heap_objects",
+		"gc_count", "gc_pause_total_ms", "gc_pause_last_ms", "cpu_percent", "uptime"} {
+		assert.Contains(t, stats, k)
+	}
+	assert.True(t, stats["goroutines"].(int) > 0)
+
+	// cached until the interval passes
+	stats["goroutines"] = -1
+	again, _ := c.Collect()
+	assert.NotEqual(t, -1, again["goroutines"])
+	assert.Equal(t, stats["heap_alloc"], again["heap_alloc"])
+}
+
+func TestProcessCollectorFields(t *testing.T) {
+	c, err := NewProcessCollector(0, FieldGoroutines, FieldUptime)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultProcessInterval, c.interval)
+
+	stats, err := c.Collect()
+	assert.NoError(t, err)
+	assert.Len(t, stats, 2)
+	assert.Contains(t, stats, "goroutines")
+	assert.Contains(t, stats, "uptime")
+}
+
+func TestProcessCollectorRegister(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	r.RemoveSink(KafkaSinkName)
+	c, err := NewProcessCollector(time.Minute, FieldGoroutines)
+	assert.NoError(t, err)
+	c.Register(r)
+
+	assert.Nil(t, r.GetStat(ProcessStatsKey))
+	r.ReportHealth()
+	stats := r.GetStat(ProcessStatsKey).(map[string]interface{})
+	assert.Contains(t, stats, "goroutines")
+
+	// usable from rules
+	assert.NoError(t, r.AddRule(ThresholdRule{Stat: "process.goroutines", Op: ">", Yellow: threshold(0)}))
+	assert.Equal(t, Yellow, r.EvaluateRules()[0].State)
+}
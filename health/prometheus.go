@@ -0,0 +1,218 @@
+package health
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/atsu/goat/build"
+)
+
+// PrometheusContentType is the content type of the prometheus text exposition format
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// states in the order they are exported as health_state
+var allStates = []State{Blue, Green, Yellow, Red, Gray}
+
+// MetricsHandler renders the current health in the prometheus text exposition format.
+// Every numeric (or bool) stat becomes a gauge named `<service>_<stat>`, nested stats are
+// flattened with '_', and non numeric stats are skipped. Typed metrics keep their type.
+func (r *Reporter) MetricsHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", PrometheusContentType)
+	_, err := w.Write(r.prometheusMetrics())
+	r.errorHandler("could not write metrics response", err)
+}
+
+func (r *Reporter) prometheusMetrics() []byte {
+	h := r.dampedHealth()
+	healthy, _ := r.KafkaHealthy()
+	info := build.GetInfo(r.service)
+	labels := promLabels("service", h.Service, "host", h.Hostname)
+
+	var buf bytes.Buffer
+
+	writePromHeader(&buf, "health_state", "gauge", "Current health state, 1 for the active state.")
+	for _, s := range allStates {
+		fmt.Fprintf(&buf, "health_state%s %d\n", promLabels("service", h.Service, "host", h.Hostname, "state", s.String()), promBool(h.State == s))
+	}
+
+	writePromHeader(&buf, "health_kafka_healthy", "gauge", "1 if the reporter can reach kafka.")
+	fmt.Fprintf(&buf, "health_kafka_healthy%s %d\n", labels, promBool(healthy))
+
+	writePromHeader(&buf, "build_info", "gauge", "Build information, always 1.")
+	fmt.Fprintf(&buf, "build_info%s 1\n", promLabels("component", info.Component, "version", info.Version,
+		"commit", info.CommitHash, "builddate", info.Date, "agent", info.Agent, "semver", info.SemVer))
+
+	// typed metrics are rendered with their own type, so they are left out of the stats
+	typed := make(map[string]metric)
+	r.metrics.Range(func(k, rm interface{}) bool {
+		typed[k.(string)] = rm.(*registeredMetric).m
+		return true
+	})
+	writePromTyped(&buf, promName(h.Service), labels, typed)
+
+	stats := make(map[string]float64)
+	if data, ok := h.Data.(map[string]interface{}); ok {
+		for k := range typed {
+			delete(data, k)
+		}
+		flattenPromStats(stats, promName(h.Service), data)
+	}
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writePromHeader(&buf, name, "gauge", fmt.Sprintf("Health stat %s.", name))
+		fmt.Fprintf(&buf, "%s%s %s\n", name, labels, promFloat(stats[name]))
+	}
+
+	return buf.Bytes()
+}
+
+// writePromTyped renders counters, gauges, histograms and timers, sorted by name
+func writePromTyped(buf *bytes.Buffer, prefix, labels string, typed map[string]metric) {
+	keys := make([]string, 0, len(typed))
+	for k := range typed {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		name := promName(prefix + "_" + k)
+		switch m := typed[k].(type) {
+		case *Counter:
+			writePromHeader(buf, name, "counter", fmt.Sprintf("Health counter %s.", k))
+			fmt.Fprintf(buf, "%s%s %d\n", name, labels, m.Value())
+		case *Gauge:
+			writePromHeader(buf, name, "gauge", fmt.Sprintf("Health gauge %s.", k))
+			fmt.Fprintf(buf, "%s%s %s\n", name, labels, promFloat(m.Value()))
+		case *Histogram:
+			writePromHeader(buf, name, "histogram", fmt.Sprintf("Health histogram %s.", k))
+			writePromHistogram(buf, name, labels, m.bounds, m.Snapshot())
+		case *Timer:
+			writePromHeader(buf, name, "histogram", fmt.Sprintf("Health timer %s in seconds.", k))
+			writePromHistogram(buf, name, labels, m.h.bounds, m.Snapshot())
+		}
+	}
+}
+
+func writePromHistogram(buf *bytes.Buffer, name, labels string, bounds []float64, s HistogramSnapshot) {
+	// le is appended to the existing labels, which always end in '}'
+	for i := 0; i <= len(bounds); i++ {
+		le := bucketLabel(bounds, i)
+		fmt.Fprintf(buf, "%s_bucket%s,le=\"%s\"} %d\n", name, labels[:len(labels)-1], le, s.Buckets[le])
+	}
+	fmt.Fprintf(buf, "%s_sum%s %s\n", name, labels, promFloat(s.Sum))
+	fmt.Fprintf(buf, "%s_count%s %d\n", name, labels, s.Count)
+}
+
+// flattenPromStats adds every numeric value in data to out, keyed by its sanitized metric name
+func flattenPromStats(out map[string]float64, prefix string, data map[string]interface{}) {
+	for k, v := range data {
+		name := promName(prefix + "_" + k)
+		if nested, ok := v.(map[string]interface{}); ok {
+			flattenPromStats(out, name, nested)
+			continue
+		}
+		if f, ok := promValue(v); ok {
+			out[name] = f
+		}
+	}
+}
+
+// promValue converts numeric, bool or numeric string stats to a float
+func promValue(v interface{}) (float64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Bool:
+		return float64(promBool(rv.Bool())), true
+	case reflect.String:
+		f, err := strconv.ParseFloat(rv.String(), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// promName sanitizes a metric name to match [a-zA-Z_][a-zA-Z0-9_]*
+// (colons are valid, but reserved for recording rules)
+func promName(name string) string {
+	var sb strings.Builder
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+			sb.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				sb.WriteRune('_')
+			}
+			sb.WriteRune(c)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	if sb.Len() == 0 {
+		return "_"
+	}
+	return sb.String()
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabels renders alternating label names and values as {name="value",...}
+func promLabels(kv ...string) string {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		name := promName(kv[i])
+		if strings.HasPrefix(name, "__") { // reserved for internal use
+			name = strings.TrimLeft(name, "_")
+		}
+		fmt.Fprintf(&sb, `%s="%s"`, name, promLabelEscaper.Replace(kv[i+1]))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+var promHelpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func writePromHeader(buf *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, promHelpEscaper.Replace(help), name, typ)
+}
+
+func promFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func promBool(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,97 @@
+package health
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	r := NewReporter("my-svc", "test", "test", func(error) {})
+	r.hostname = "host\"1"
+	r.SetHealth(Yellow, "meh")
+	r.PersistStat("uptime", 12.5)
+	r.AddStat("errors", 3)
+	r.AddStat("ok", true)
+	r.AddStat("latency", time.Duration(2))
+	r.AddStat("numeric string", "42")
+	r.AddStat("name", "not a number")
+	r.AddStat("nested", map[string]interface{}{"inner.count": uint8(7), "skip": []int{1}})
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	http.HandlerFunc(r.MetricsHandler).ServeHTTP(rr, req)
+
+	assert.Equal(t, PrometheusContentType, rr.Header().Get("Content-Type"))
+	body := rr.Body.String()
+	labels := `{service="my-svc",host="host\"1"}`
+
+	for _, line := range []string{
+		"# HELP health_state Current health state, 1 for the active state.",
+		"# TYPE health_state gauge",
+		`health_state{service="my-svc",host="host\"1",state="yellow"} 1`,
+		`health_state{service="my-svc",host="host\"1",state="green"} 0`,
+		"health_kafka_healthy" + labels + " 0",
+		"# TYPE build_info gauge",
+		"# TYPE my_svc_errors gauge",
+		"my_svc_errors" + labels + " 3",
+		"my_svc_ok" + labels + " 1",
+		"my_svc_uptime" + labels + " 12.5",
+		"my_svc_latency" + labels + " 2",
+		"my_svc_numeric_string" + labels + " 42",
+		"my_svc_nested_inner_count" + labels + " 7",
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+	assert.Contains(t, body, `build_info{component="`)
+	assert.NotContains(t, body, "my_svc_name")
+	assert.NotContains(t, body, "my_svc_nested_skip")
+
+	// every sample has a HELP and TYPE line
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := line[:strings.IndexAny(line, "{ ")]
+		assert.Contains(t, body, "# TYPE "+name+" ")
+		assert.Contains(t, body, "# HELP "+name+" ")
+	}
+
+	assert.NoError(t, r.Stop())
+}
+
+func TestPromName(t *testing.T) {
+	tests := map[string]string{
+		"simple":      "simple",
+		"with.dots":   "with_dots",
+		"dash-es":     "dash_es",
+		"9lives":      "_9lives",
+		"colon:name":  "colon_name",
+		"ünï":         "_n_",
+		"":            "_",
+		"CamelCase_1": "CamelCase_1",
+	}
+	for in, want := range tests {
+		assert.Equal(t, want, promName(in), in)
+	}
+}
+
+func TestPromLabels(t *testing.T) {
+	assert.Equal(t, `{a="b"}`, promLabels("a", "b"))
+	assert.Equal(t, `{bad_name="x",reserved="y"}`, promLabels("bad-name", "x", "__reserved", "y"))
+	assert.Equal(t, `{v="a\\b\"c\nd"}`, promLabels("v", "a\\b\"c\nd"))
+	assert.Equal(t, `{}`, promLabels())
+}
+
+func TestPromFloat(t *testing.T) {
+	assert.Equal(t, "NaN", promFloat(math.NaN()))
+	assert.Equal(t, "+Inf", promFloat(math.Inf(1)))
+	assert.Equal(t, "-Inf", promFloat(math.Inf(-1)))
+	assert.Equal(t, "0.25", promFloat(0.25))
+	assert.Equal(t, "1e+21", promFloat(1e21))
+}
@@ -0,0 +1,267 @@
+package health
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RulesDataKey is the Event.Data key listing the rules that matched on the last report
+const RulesDataKey = "rules"
+
+// DefaultRuleMessage is used by rules without a message template
+const DefaultRuleMessage = "{{.Stat}} is {{.Value}} ({{.Op}} {{.Threshold}})"
+
+// comparisons supported by ThresholdRule.Op
+var comparisons = map[string]func(v, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+// ThresholdRule derives a state from a numeric stat, e.g. Yellow when `errors > 10` and Red when `errors > 100`.
+// Nested stats are addressed with '.', e.g. `checks.db.duration_ms`. Rules whose stat is missing or
+// not numeric don't match.
+type ThresholdRule struct {
+	Stat    string   `json:"stat" yaml:"stat"`
+	Op      string   `json:"op" yaml:"op"` // one of > >= < <= == !=
+	Yellow  *float64 `json:"yellow,omitempty" yaml:"yellow,omitempty"`
+	Red     *float64 `json:"red,omitempty" yaml:"red,omitempty"`
+	Message string   `json:"msg,omitempty" yaml:"msg,omitempty"` // text/template of a RuleMatch, DefaultRuleMessage if unset
+}
+
+// RuleSet is the YAML (or json) document loaded by LoadRules
+//
+//	rules:
+//	  - stat: errors
+//	    op: ">"
+//	    yellow: 10
+//	    red: 100
+//	    msg: "{{.Value}} errors"
+type RuleSet struct {
+	Rules []ThresholdRule `json:"rules" yaml:"rules"`
+}
+
+// RuleMatch is a rule that matched, as listed in Event.Data and passed to the message template
+type RuleMatch struct {
+	Stat      string  `json:"stat"`
+	Op        string  `json:"op"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	State     State   `json:"state"`
+	Message   string  `json:"msg"`
+}
+
+type rule struct {
+	ThresholdRule
+	cmp  func(v, threshold float64) bool
+	tmpl *template.Template
+}
+
+func newRule(tr ThresholdRule) (*rule, error) {
+	if tr.Stat == "" {
+		return nil, fmt.Errorf("rule has no stat")
+	}
+	cmp, ok := comparisons[tr.Op]
+	if !ok {
+		return nil, fmt.Errorf("rule %s: unknown comparison %q", tr.Stat, tr.Op)
+	}
+	if tr.Yellow == nil && tr.Red == nil {
+		return nil, fmt.Errorf("rule %s: no yellow or red threshold", tr.Stat)
+	}
+	msg := tr.Message
+	if msg == "" {
+		msg = DefaultRuleMessage
+	}
+	tmpl, err := template.New(tr.Stat).Parse(msg)
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: %v", tr.Stat, err)
+	}
+	return &rule{ThresholdRule: tr, cmp: cmp, tmpl: tmpl}, nil
+}
+
+// eval returns the match of the worst threshold crossed by the stat in data, if any
+func (ru *rule) eval(data map[string]interface{}) (RuleMatch, bool) {
+	v, ok := lookupStat(data, ru.Stat)
+	if !ok {
+		return RuleMatch{}, false
+	}
+	f, ok := promValue(v)
+	if !ok {
+		return RuleMatch{}, false
+	}
+
+	m := RuleMatch{Stat: ru.Stat, Op: ru.Op, Value: f}
+	switch {
+	case ru.Red != nil && ru.cmp(f, *ru.Red):
+		m.State, m.Threshold = Red, *ru.Red
+	case ru.Yellow != nil && ru.cmp(f, *ru.Yellow):
+		m.State, m.Threshold = Yellow, *ru.Yellow
+	default:
+		return RuleMatch{}, false
+	}
+
+	var buf bytes.Buffer
+	if err := ru.tmpl.Execute(&buf, m); err != nil {
+		m.Message = fmt.Sprintf("%s is %v (%s %v)", m.Stat, m.Value, m.Op, m.Threshold)
+	} else {
+		m.Message = buf.String()
+	}
+	return m, true
+}
+
+// lookupStat finds a stat by key, descending into nested maps on '.'.
+// Nested values of other types, e.g. map[string]CheckStatus, are looked up in their JSON form.
+func lookupStat(data map[string]interface{}, key string) (interface{}, bool) {
+	if v, ok := data[key]; ok {
+		return v, true
+	}
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) < 2 {
+		return nil, false
+	}
+	nested, ok := data[parts[0]].(map[string]interface{})
+	if !ok {
+		if nested, ok = normalizeStat(data[parts[0]]); !ok {
+			return nil, false
+		}
+	}
+	return lookupStat(nested, parts[1])
+}
+
+// normalizeStat returns v as reported, i.e. after a JSON round trip, if that is an object
+func normalizeStat(v interface{}) (map[string]interface{}, bool) {
+	if v == nil {
+		return nil, false
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, false
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(b, &out); err != nil || out == nil {
+		return nil, false
+	}
+	return out, true
+}
+
+type ruleSet struct {
+	mux     sync.Mutex
+	rules   []*rule
+	matches []RuleMatch // from the last evaluation, worst first
+}
+
+// AddRule adds a threshold rule, evaluated before each ReportHealth
+func (r *Reporter) AddRule(tr ThresholdRule) error {
+	ru, err := newRule(tr)
+	if err != nil {
+		return err
+	}
+	r.rules.mux.Lock()
+	defer r.rules.mux.Unlock()
+	r.rules.rules = append(r.rules.rules, ru)
+	return nil
+}
+
+// SetRules replaces all threshold rules, or returns an error leaving the rules unchanged
+func (r *Reporter) SetRules(rules []ThresholdRule) error {
+	parsed := make([]*rule, 0, len(rules))
+	for _, tr := range rules {
+		ru, err := newRule(tr)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, ru)
+	}
+	r.rules.mux.Lock()
+	defer r.rules.mux.Unlock()
+	r.rules.rules = parsed
+	r.rules.matches = nil
+	return nil
+}
+
+// LoadRules replaces all threshold rules with those in a YAML RuleSet
+func (r *Reporter) LoadRules(b []byte) error {
+	var rs RuleSet
+	if err := yaml.UnmarshalStrict(b, &rs); err != nil {
+		return err
+	}
+	return r.SetRules(rs.Rules)
+}
+
+// LoadRulesFile replaces all threshold rules with those in a YAML RuleSet file
+func (r *Reporter) LoadRulesFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return r.LoadRules(b)
+}
+
+// ClearRules removes all threshold rules
+func (r *Reporter) ClearRules() {
+	r.rules.mux.Lock()
+	defer r.rules.mux.Unlock()
+	r.rules.rules = nil
+	r.rules.matches = nil
+}
+
+// EvaluateRules evaluates the threshold rules against the current stats, this is done before each ReportHealth.
+// It returns the matching rules, worst first.
+func (r *Reporter) EvaluateRules() []RuleMatch {
+	r.rules.mux.Lock()
+	empty := len(r.rules.rules) == 0
+	r.rules.mux.Unlock()
+	if empty {
+		return nil
+	}
+
+	// evaluated without holding the rule lock, since Health reads the last matches
+	data, _ := r.Health().Data.(map[string]interface{})
+	delete(data, RulesDataKey) // matches from the last evaluation
+
+	r.rules.mux.Lock()
+	defer r.rules.mux.Unlock()
+	var matches []RuleMatch
+	for _, ru := range r.rules.rules {
+		if m, ok := ru.eval(data); ok {
+			matches = append(matches, m)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].State.Worse(matches[j].State) })
+	r.rules.matches = matches
+	return append([]RuleMatch(nil), matches...)
+}
+
+func (rs *ruleSet) lastMatches() []RuleMatch {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+	return rs.matches
+}
+
+// aggregateRules returns the worst of state and the matched rules, along with the message to report.
+// When rules are worse than state, the messages of the worst rules are joined.
+func aggregateRules(state State, message string, matches []RuleMatch) (State, string) {
+	if len(matches) == 0 || !matches[0].State.Worse(state) {
+		return state, message
+	}
+	worst := matches[0].State
+	var msgs []string
+	for _, m := range matches {
+		if m.State != worst {
+			break
+		}
+		msgs = append(msgs, m.Message)
+	}
+	return worst, strings.Join(msgs, "; ")
+}
@@ -0,0 +1,148 @@
+package health
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func threshold(f float64) *float64 { return &f }
+
+func TestNewRule(t *testing.T) {
+	_, err := newRule(ThresholdRule{Op: ">", Red: threshold(1)})
+	assert.EqualError(t, err, "rule has no stat")
+	_, err = newRule(ThresholdRule{Stat: "a", Op: "=>", Red: threshold(1)})
+	assert.EqualError(t, err, `rule a: unknown comparison "=>"`)
+	_, err = newRule(ThresholdRule{Stat: "a", Op: ">"})
+	assert.EqualError(t, err, "rule a: no yellow or red threshold")
+	_, err = newRule(ThresholdRule{Stat: "a", Op: ">", Red: threshold(1), Message: "{{.Nope"})
+	assert.Error(t, err)
+}
+
+func TestRuleEval(t *testing.T) {
+	data := map[string]interface{}{
+		"errors": 15,
+		"free":   "0.05",
+		"name":   "x",
+		"db":     map[string]interface{}{"latency": 250.0},
+	}
+	tests := []struct {
+		rule  ThresholdRule
+		ok    bool
+		state State
+		msg   string
+	}{
+		{ThresholdRule{Stat: "errors", Op: ">", Yellow: threshold(10), Red: threshold(100)}, true, Yellow, "errors is 15 (> 10)"},
+		{ThresholdRule{Stat: "errors", Op: ">=", Yellow: threshold(10), Red: threshold(15), Message: "{{.Value}} errors"}, true, Red, "15 errors"},
+		{ThresholdRule{Stat: "errors", Op: ">", Yellow: threshold(20)}, false, "", ""},
+		{ThresholdRule{Stat: "free", Op: "<", Yellow: threshold(0.2), Red: threshold(0.1)}, true, Red, "free is 0.05 (< 0.1)"},
+		{ThresholdRule{Stat: "db.latency", Op: ">", Red: threshold(200)}, true, Red, "db.latency is 250 (> 200)"},
+		{ThresholdRule{Stat: "db.missing", Op: ">", Red: threshold(0)}, false, "", ""},
+		{ThresholdRule{Stat: "name", Op: "!=", Red: threshold(0)}, false, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.rule.Stat, func(t *testing.T) {
+			ru, err := newRule(test.rule)
+			assert.NoError(t, err)
+			m, ok := ru.eval(data)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.state, m.State)
+			assert.Equal(t, test.msg, m.Message)
+		})
+	}
+}
+
+func TestReporterRules(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	r.RemoveSink(KafkaSinkName)
+	r.SetHealth(Green, "ok")
+
+	assert.NoError(t, r.LoadRules([]byte(`
+rules:
+  - stat: errors
+    op: ">"
+    yellow: 10
+    red: 100
+  - stat: queue
+    op: ">="
+    yellow: 5
+    msg: "queue backing up ({{.Value}})"
+`)))
+
+	r.AddStat("errors", 11)
+	r.AddStat("queue", 5)
+	r.ReportHealth()
+	h := r.Health()
+	assert.Equal(t, Yellow, h.State)
+	assert.Equal(t, "errors is 11 (> 10); queue backing up (5)", h.Message)
+	assert.Len(t, h.Data.(map[string]interface{})[RulesDataKey], 2)
+
+	// the worst rule wins
+	r.AddStat("errors", 500)
+	matches := r.EvaluateRules()
+	assert.Equal(t, Red, matches[0].State)
+	h = r.Health()
+	assert.Equal(t, Red, h.State)
+	assert.Equal(t, "errors is 500 (> 100)", h.Message)
+
+	// and a recovered stat restores the manual state
+	r.ClearStats()
+	r.ReportHealth()
+	h = r.Health()
+	assert.Equal(t, Green, h.State)
+	assert.Equal(t, "ok", h.Message)
+	_, ok := h.Data.(map[string]interface{})[RulesDataKey]
+	assert.False(t, ok)
+
+	// rules don't hide a worse manual state
+	r.AddStat("errors", 11)
+	r.SetHealth(Red, "manual")
+	r.ReportHealth()
+	assert.Equal(t, "manual", r.Health().Message)
+
+	// invalid rules leave the existing ones
+	assert.Error(t, r.LoadRules([]byte("rules:\n  - stat: a\n    op: '~'\n    red: 1\n")))
+	assert.Error(t, r.LoadRules([]byte("rules:\n  - stat: a\n    bogus: 1\n")))
+	assert.Len(t, r.EvaluateRules(), 1)
+
+	r.ClearRules()
+	assert.Empty(t, r.EvaluateRules())
+}
+
+func TestRuleOnCheckDuration(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	r.SetHealth(Green, "ok")
+	r.RegisterCheck("db", func(ctx context.Context) CheckResult {
+		time.Sleep(time.Millisecond * 20)
+		return CheckResult{State: Green, Message: "ok"}
+	}, CheckOptions{Interval: time.Hour})
+	defer r.UnregisterCheck("db")
+	r.RunChecks()
+
+	assert.NoError(t, r.AddRule(ThresholdRule{Stat: "checks.db.duration_ms", Op: ">=", Yellow: threshold(10)}))
+	matches := r.EvaluateRules()
+	assert.Len(t, matches, 1)
+	assert.Equal(t, Yellow, matches[0].State)
+	assert.True(t, matches[0].Value >= 20)
+	assert.Equal(t, Yellow, r.Health().State)
+}
+
+func TestLoadRulesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("rules:\n  - {stat: errors, op: '>', red: 0}\n"), 0644))
+
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	assert.NoError(t, r.LoadRulesFile(path))
+	r.AddStat("errors", 1)
+	assert.Len(t, r.EvaluateRules(), 1)
+	assert.Error(t, r.LoadRulesFile(filepath.Join(dir, "missing.yaml")))
+}
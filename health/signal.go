@@ -2,13 +2,47 @@ package health
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"sync"
 	"time"
 )
 
 const SignalUrl = "https://signal.atsu.io/"
 
+const (
+	DefaultSignalTimeout    = time.Second * 10
+	DefaultSignalRetries    = 3
+	DefaultSignalMinBackoff = time.Millisecond * 250
+	DefaultSignalMaxBackoff = time.Second * 5
+	DefaultSignalBatchWait  = time.Second * 5
+	DefaultSignalDrainWait  = time.Second * 10
+)
+
+// ErrSignalClosed is returned by reports after the signal is closed
+var ErrSignalClosed = errors.New("signal is closed")
+
+// SignatureHeader carries the hex encoded HMAC-SHA256 of the request body as sent, prefixed with `sha256=`
+const SignatureHeader = "X-Signature"
+
+// SignatureKeyIdHeader names the key used for SignatureHeader, if a key id is configured
+const SignatureKeyIdHeader = "X-Signature-Key-Id"
+
+// maxErrorBody is the most of a response body included in a StatusError
+const maxErrorBody = 1024
+
+// maxDrainBody is the most of a response body read to reuse the connection, larger bodies close it
+const maxDrainBody = 64 * 1024
+
 // Signal is a mechanism for sending a health.Event and Data (interface{})
 type Signal interface {
 	Report(Event, interface{}) error
@@ -16,17 +50,87 @@ type Signal interface {
 	RawReport([]byte) error
 }
 
+// StatusError is returned when the signal endpoint responds with a non 2xx status
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string // at most the first 1KB
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("signal: unexpected status %s", e.Status)
+	}
+	return fmt.Sprintf("signal: unexpected status %s: %s", e.Status, e.Body)
+}
+
+// HttpSignalOptions configures an HttpSignal, zero values mean the defaults
+type HttpSignalOptions struct {
+	Client     *http.Client  // defaults to a client with DefaultSignalTimeout
+	MaxRetries int           // retries on network errors and 5xx responses, -1 disables, 0 means DefaultSignalRetries
+	MinBackoff time.Duration // first retry delay, doubling on each retry
+	MaxBackoff time.Duration // longest retry delay
+
+	BatchSize int           // > 1 batches events into one NDJSON request of up to BatchSize events
+	BatchWait time.Duration // longest an event waits in a partial batch, DefaultSignalBatchWait if unset
+	DrainWait time.Duration // longest Close waits to send the batched events, DefaultSignalDrainWait if unset
+	Errfn     func(error)   // receives errors from sending partial batches in the background
+
+	Gzip        bool    // gzip request bodies
+	BearerToken string  // sent as an Authorization: Bearer header
+	HMACKey     []byte  // signs request bodies, see SignatureHeader
+	HMACKeyId   string  // sent as SignatureKeyIdHeader
+	Signer      *Signer // signs each event, unlike HMACKey which signs the request body
+}
+
 // HttpSignal implements against our canonical endpoint SignalUrl
 type HttpSignal struct {
 	client *http.Client
 	url    string
+	opts   HttpSignalOptions
+
+	mux    sync.Mutex
+	batch  [][]byte
+	closed bool
+	doneCh chan struct{}
+	wg     sync.WaitGroup
 }
 
 func NewHttpSignal(url string) *HttpSignal {
-	c := &http.Client{
-		Timeout: time.Second * 10,
+	return NewHttpSignalWithOptions(url, HttpSignalOptions{})
+}
+
+// NewHttpSignalWithOptions creates an HttpSignal, when batching is enabled Close must be called
+// to send the final partial batch.
+func NewHttpSignalWithOptions(url string, opts HttpSignalOptions) *HttpSignal {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: DefaultSignalTimeout}
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultSignalRetries
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultSignalMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = DefaultSignalMaxBackoff
+		if opts.MaxBackoff < opts.MinBackoff {
+			opts.MaxBackoff = opts.MinBackoff
+		}
+	}
+	if opts.BatchWait <= 0 {
+		opts.BatchWait = DefaultSignalBatchWait
+	}
+	if opts.DrainWait <= 0 {
+		opts.DrainWait = DefaultSignalDrainWait
+	}
+
+	s := &HttpSignal{client: opts.Client, url: url, opts: opts, doneCh: make(chan struct{})}
+	if opts.BatchSize > 1 {
+		s.wg.Add(1)
+		go s.flushLoop()
 	}
-	return &HttpSignal{client: c, url: url}
+	return s
 }
 
 // Report will Marshal and send, or error
@@ -41,8 +145,189 @@ func (r *HttpSignal) Report(e Event, i interface{}) error {
 	return r.RawReport(b)
 }
 
-// RawReport will POST the provided jsonPayload to SignalUrl
+// RawReport will POST the provided jsonPayload to SignalUrl, or add it to the current batch
+// if batching is enabled, in which case an error is only returned when a full batch fails to send.
+// Once closed ErrSignalClosed is returned.
 func (r *HttpSignal) RawReport(jsonPayload []byte) error {
-	_, err := r.client.Post(r.url, "application/json", bytes.NewBuffer(jsonPayload))
-	return err
+	r.mux.Lock()
+	closed := r.closed
+	r.mux.Unlock()
+	if closed {
+		return ErrSignalClosed
+	}
+	if r.opts.Signer != nil {
+		signed, err := r.opts.Signer.SignBytes(jsonPayload)
+		if err != nil {
+			return err
+		}
+		jsonPayload = signed
+	}
+	if r.opts.BatchSize < 2 {
+		return r.send(context.Background(), jsonPayload, "application/json")
+	}
+
+	r.mux.Lock()
+	if r.closed { // closed while signing
+		r.mux.Unlock()
+		return ErrSignalClosed
+	}
+	r.batch = append(r.batch, append([]byte(nil), jsonPayload...))
+	full := len(r.batch) >= r.opts.BatchSize
+	r.mux.Unlock()
+
+	if full {
+		return r.Flush()
+	}
+	return nil
+}
+
+// Flush sends any batched events now
+func (r *HttpSignal) Flush() error {
+	return r.flush(context.Background())
+}
+
+func (r *HttpSignal) flush(ctx context.Context) error {
+	r.mux.Lock()
+	batch := r.batch
+	r.batch = nil
+	r.mux.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	var body bytes.Buffer
+	for _, b := range batch {
+		body.Write(bytes.TrimRight(b, "\n"))
+		body.WriteByte('\n')
+	}
+	return r.send(ctx, body.Bytes(), "application/x-ndjson")
+}
+
+// Close stops background batching and sends any batched events, giving up after DrainWait.
+// Reports after Close return ErrSignalClosed.
+func (r *HttpSignal) Close() error {
+	r.mux.Lock()
+	if r.closed {
+		r.mux.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.doneCh)
+	r.mux.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.opts.DrainWait)
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+	}
+	return r.flush(ctx)
+}
+
+func (r *HttpSignal) flushLoop() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.opts.BatchWait)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.doneCh:
+			return
+		case <-ticker.C:
+			if err := r.Flush(); err != nil && r.opts.Errfn != nil {
+				r.opts.Errfn(err)
+			}
+		}
+	}
+}
+
+// send POSTs payload, retrying network errors and 5xx responses with exponential backoff
+func (r *HttpSignal) send(ctx context.Context, payload []byte, contentType string) error {
+	body := payload
+	if r.opts.Gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(payload); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	backoff := r.opts.MinBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = r.post(ctx, body, contentType)
+		if err == nil || !retry || attempt >= r.opts.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-r.doneCh:
+			// closing, don't keep the caller waiting
+			return err
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+		if backoff > r.opts.MaxBackoff {
+			backoff = r.opts.MaxBackoff
+		}
+	}
+}
+
+// post makes a single request, returning whether a failure may be retried
+func (r *HttpSignal) post(ctx context.Context, body []byte, contentType string) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	if r.opts.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if r.opts.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+r.opts.BearerToken)
+	}
+	if len(r.opts.HMACKey) > 0 {
+		mac := hmac.New(sha256.New, r.opts.HMACKey)
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		if r.opts.HMACKeyId != "" {
+			req.Header.Set(SignatureKeyIdHeader, r.opts.HMACKeyId)
+		}
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		// a request cut short by ctx isn't retried
+		return ctx.Err() == nil, err
+	}
+	defer func() {
+		// drain so the connection can be reused, unless the body is too large to be worth it
+		_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainBody))
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return resp.StatusCode >= 500, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(bytes.TrimSpace(b))}
+}
+
+// IsStatusError returns the *StatusError in err's chain, if any
+func IsStatusError(err error) (*StatusError, bool) {
+	var se *StatusError
+	ok := errors.As(err, &se)
+	return se, ok
 }
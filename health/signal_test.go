@@ -0,0 +1,204 @@
+package health
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttpSignalReport(t *testing.T) {
+	var got Event
+	var contentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		contentType = req.Header.Get("Content-Type")
+		b, _ := ioutil.ReadAll(req.Body)
+		assert.NoError(t, json.Unmarshal(b, &got))
+	}))
+	defer srv.Close()
+
+	s := NewHttpSignal(srv.URL)
+	assert.NoError(t, s.Report(Event{Service: "svc", State: Green}, map[string]interface{}{"a": 1}))
+	assert.Equal(t, "application/json", contentType)
+	assert.Equal(t, "svc", got.Service)
+	assert.Equal(t, Green, got.State)
+}
+
+func TestHttpSignalStatusErrors(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		switch req.URL.Path {
+		case "/bad":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("missing service\n"))
+		case "/flaky":
+			if n < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	opts := HttpSignalOptions{MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond * 2}
+
+	// 4xx is not retried
+	err := NewHttpSignalWithOptions(srv.URL+"/bad", opts).RawReport([]byte(`{}`))
+	se, ok := IsStatusError(err)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusBadRequest, se.StatusCode)
+	assert.Equal(t, "missing service", se.Body)
+	assert.EqualError(t, err, "signal: unexpected status 400 Bad Request: missing service")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// 5xx is retried until it succeeds
+	atomic.StoreInt32(&calls, 0)
+	assert.NoError(t, NewHttpSignalWithOptions(srv.URL+"/flaky", opts).RawReport([]byte(`{}`)))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// or retries run out
+	atomic.StoreInt32(&calls, 0)
+	err = NewHttpSignalWithOptions(srv.URL+"/down", opts).RawReport([]byte(`{}`))
+	se, ok = IsStatusError(err)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusInternalServerError, se.StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// retries can be disabled
+	atomic.StoreInt32(&calls, 0)
+	opts.MaxRetries = -1
+	assert.Error(t, NewHttpSignalWithOptions(srv.URL+"/down", opts).RawReport([]byte(`{}`)))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestHttpSignalNetworkError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	url := srv.URL
+	srv.Close()
+
+	s := NewHttpSignalWithOptions(url, HttpSignalOptions{MaxRetries: 1, MinBackoff: time.Millisecond})
+	err := s.RawReport([]byte(`{}`))
+	assert.Error(t, err)
+	_, ok := IsStatusError(err)
+	assert.False(t, ok)
+}
+
+func TestHttpSignalContextNotRetried(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	s := NewHttpSignalWithOptions(srv.URL, HttpSignalOptions{MaxRetries: 5, MinBackoff: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	start := time.Now()
+	assert.Error(t, s.send(ctx, []byte(`{}`), "application/json"))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestHttpSignalAuthAndGzip(t *testing.T) {
+	key := []byte("secret")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		assert.Equal(t, "Bearer tok", req.Header.Get("Authorization"))
+		assert.Equal(t, "gzip", req.Header.Get("Content-Encoding"))
+		assert.Equal(t, "k1", req.Header.Get(SignatureKeyIdHeader))
+
+		mac := hmac.New(sha256.New, key)
+		mac.Write(body)
+		assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), req.Header.Get(SignatureHeader))
+
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		assert.NoError(t, err)
+		plain, _ := ioutil.ReadAll(zr)
+		assert.Equal(t, `{"service":"svc"}`, string(plain))
+	}))
+	defer srv.Close()
+
+	s := NewHttpSignalWithOptions(srv.URL, HttpSignalOptions{Gzip: true, BearerToken: "tok", HMACKey: key, HMACKeyId: "k1"})
+	assert.NoError(t, s.RawReport([]byte(`{"service":"svc"}`)))
+}
+
+func TestHttpSignalBatching(t *testing.T) {
+	var mux sync.Mutex
+	var batches [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "application/x-ndjson", req.Header.Get("Content-Type"))
+		var lines []string
+		sc := bufio.NewScanner(req.Body)
+		for sc.Scan() {
+			lines = append(lines, sc.Text())
+		}
+		mux.Lock()
+		batches = append(batches, lines)
+		mux.Unlock()
+	}))
+	defer srv.Close()
+	count := func() int {
+		mux.Lock()
+		defer mux.Unlock()
+		return len(batches)
+	}
+
+	s := NewHttpSignalWithOptions(srv.URL, HttpSignalOptions{BatchSize: 3, BatchWait: time.Hour})
+	assert.NoError(t, s.RawReport([]byte(`{"n":1}`)))
+	assert.NoError(t, s.RawReport([]byte("{\"n\":2}\n")))
+	assert.Equal(t, 0, count())
+	assert.NoError(t, s.RawReport([]byte(`{"n":3}`)))
+	assert.Equal(t, 1, count())
+
+	// the partial batch is sent on close
+	assert.NoError(t, s.RawReport([]byte(`{"n":4}`)))
+	assert.NoError(t, s.Close())
+	assert.NoError(t, s.Close())
+	assert.Equal(t, [][]string{{`{"n":1}`, `{"n":2}`, `{"n":3}`}, {`{"n":4}`}}, batches)
+
+	// or after BatchWait
+	s = NewHttpSignalWithOptions(srv.URL, HttpSignalOptions{BatchSize: 3, BatchWait: time.Millisecond * 10})
+	defer s.Close()
+	assert.NoError(t, s.RawReport([]byte(`{"n":5}`)))
+	assert.Eventually(t, func() bool { return count() == 3 }, time.Second, time.Millisecond*5)
+}
+
+func TestHttpSignalClose(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release // never answers before the drain deadline
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	s := NewHttpSignalWithOptions(srv.URL, HttpSignalOptions{BatchSize: 3, BatchWait: time.Hour, DrainWait: time.Millisecond * 50})
+	assert.NoError(t, s.RawReport([]byte(`{"n":1}`)))
+	start := time.Now()
+	assert.Error(t, s.Close())
+	assert.True(t, time.Since(start) < time.Second)
+
+	assert.Equal(t, ErrSignalClosed, s.RawReport([]byte(`{"n":2}`)))
+	assert.Equal(t, ErrSignalClosed, s.Report(Event{}, nil))
+	assert.NoError(t, s.Close())
+
+	s = NewHttpSignal(srv.URL)
+	assert.NoError(t, s.Close())
+	assert.Equal(t, ErrSignalClosed, s.RawReport([]byte(`{"n":3}`)))
+}
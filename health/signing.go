@@ -0,0 +1,256 @@
+package health
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/atsu/goat/crypto"
+)
+
+// DefaultSignatureSkew is the largest difference between the signature and verifier clocks that is accepted
+const DefaultSignatureSkew = time.Minute * 5
+
+// SignatureKey is the JSON key of the Signature in a signed event
+const SignatureKey = "sig"
+
+var (
+	ErrUnsigned          = errors.New("event is not signed")
+	ErrUnknownKey        = errors.New("event is signed with an unknown key")
+	ErrBadSignature      = errors.New("event signature does not match")
+	ErrSignatureExpired  = errors.New("event signature timestamp is outside the skew window")
+	ErrSignatureReplayed = errors.New("event signature was already verified")
+)
+
+// Signature is the HMAC-SHA256 of the canonical JSON encoding of an event without its signature,
+// along with the key id, timestamp and nonce, see Signer.
+type Signature struct {
+	KeyId     string `json:"kid,omitempty"`
+	Timestamp int64  `json:"ts"` // unix timestamp
+	Nonce     string `json:"nonce"`
+	Value     string `json:"value"` // hex encoded
+}
+
+// Signer signs events with HMAC-SHA256
+type Signer struct {
+	keyId string
+	key   []byte
+	now   func() time.Time
+}
+
+// NewSigner creates a signer with a key, the key id is included in signatures so verifiers can rotate keys
+func NewSigner(keyId string, key []byte) *Signer {
+	return &Signer{keyId: keyId, key: key, now: time.Now}
+}
+
+// NewScryptSigner creates a signer using the key of a scrypt package, e.g. one decoded
+// with crypto.EncodedScryptPkg.Decode
+func NewScryptSigner(keyId string, pkg *crypto.ScryptPkg) *Signer {
+	return NewSigner(keyId, pkg.Key)
+}
+
+// Sign sets the signature of e
+func (s *Signer) Sign(e *Event) error {
+	e.Sig = nil
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	doc, err := decodeCanonical(b)
+	if err != nil {
+		return err
+	}
+	sig, err := s.signature(doc)
+	if err != nil {
+		return err
+	}
+	e.Sig = sig
+	return nil
+}
+
+// SignBytes returns a JSON encoded event, or any JSON object, with a signature added under SignatureKey
+func (s *Signer) SignBytes(payload []byte) ([]byte, error) {
+	doc, err := decodeCanonical(payload)
+	if err != nil {
+		return nil, err
+	}
+	delete(doc, SignatureKey)
+	sig, err := s.signature(doc)
+	if err != nil {
+		return nil, err
+	}
+	doc[SignatureKey] = sig
+	return json.Marshal(doc)
+}
+
+func (s *Signer) signature(doc map[string]interface{}) (*Signature, error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sig := &Signature{KeyId: s.keyId, Timestamp: s.now().Unix(), Nonce: hex.EncodeToString(nonce)}
+	mac, err := signatureMAC(s.key, sig, doc)
+	if err != nil {
+		return nil, err
+	}
+	sig.Value = hex.EncodeToString(mac)
+	return sig, nil
+}
+
+// SetSigner signs every event emitted by the reporter, nil disables signing.
+// Events that can't be signed are dropped and the error is sent to Errfn.
+func (r *Reporter) SetSigner(s *Signer) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.signer = s
+}
+
+// sign signs e if a signer is set, returning false if it could not be signed and must not be emitted
+func (r *Reporter) sign(e *Event) bool {
+	r.mux.Lock()
+	s := r.signer
+	r.mux.Unlock()
+	if s == nil {
+		return true
+	}
+	if err := s.Sign(e); err != nil {
+		r.errorHandler(fmt.Sprintf("could not sign %s event, dropped", e.Name), err)
+		return false
+	}
+	return true
+}
+
+// Verifier verifies signed events, rejecting signatures outside the skew window and replays within it
+type Verifier struct {
+	mux  sync.Mutex
+	keys map[string][]byte
+	skew time.Duration
+	seen map[string]bool // signature values verified within the skew window
+	// seen values in verification order, each expiring 2 skews after verification, by which time
+	// its timestamp is outside the window whatever the clock difference was
+	expiry []seenSignature
+	now    func() time.Time
+}
+
+// NewVerifier creates a verifier accepting signatures up to skew old or in the future, DefaultSignatureSkew if 0
+func NewVerifier(skew time.Duration) *Verifier {
+	if skew <= 0 {
+		skew = DefaultSignatureSkew
+	}
+	return &Verifier{keys: make(map[string][]byte), skew: skew, seen: make(map[string]bool), now: time.Now}
+}
+
+type seenSignature struct {
+	value   string
+	expires time.Time
+}
+
+// AddKey adds a key accepted for signatures with the key id
+func (v *Verifier) AddKey(keyId string, key []byte) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	v.keys[keyId] = key
+}
+
+// AddScryptKey adds the key of a scrypt package accepted for signatures with the key id
+func (v *Verifier) AddScryptKey(keyId string, pkg *crypto.ScryptPkg) {
+	v.AddKey(keyId, pkg.Key)
+}
+
+// RemoveKey stops accepting signatures with the key id
+func (v *Verifier) RemoveKey(keyId string) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	delete(v.keys, keyId)
+}
+
+// Verify checks the signature of a JSON encoded event and returns the decoded event
+func (v *Verifier) Verify(payload []byte) (Event, error) {
+	var e Event
+	doc, err := decodeCanonical(payload)
+	if err != nil {
+		return e, err
+	}
+	raw, ok := doc[SignatureKey]
+	if !ok {
+		return e, ErrUnsigned
+	}
+	delete(doc, SignatureKey)
+	var sig Signature
+	if b, err := json.Marshal(raw); err != nil || json.Unmarshal(b, &sig) != nil {
+		return e, ErrUnsigned
+	}
+
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	key, ok := v.keys[sig.KeyId]
+	if !ok {
+		return e, ErrUnknownKey
+	}
+	expected, err := signatureMAC(key, &sig, doc)
+	if err != nil {
+		return e, err
+	}
+	actual, err := hex.DecodeString(sig.Value)
+	if err != nil || !hmac.Equal(expected, actual) {
+		return e, ErrBadSignature
+	}
+
+	now := v.now()
+	if d := now.Sub(time.Unix(sig.Timestamp, 0)); d > v.skew || -d > v.skew {
+		return e, ErrSignatureExpired
+	}
+	expired := 0
+	for ; expired < len(v.expiry) && now.After(v.expiry[expired].expires); expired++ {
+		delete(v.seen, v.expiry[expired].value)
+	}
+	v.expiry = v.expiry[expired:]
+	if v.seen[sig.Value] {
+		return e, ErrSignatureReplayed
+	}
+	v.seen[sig.Value] = true
+	v.expiry = append(v.expiry, seenSignature{value: sig.Value, expires: now.Add(v.skew * 2)})
+
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return e, err
+	}
+	return e, nil
+}
+
+// decodeCanonical decodes a JSON object keeping numbers as they were encoded,
+// so that encoding it again gives the canonical form: sorted keys and no insignificant whitespace
+func decodeCanonical(b []byte) (map[string]interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var doc map[string]interface{}
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("signed payload must be a JSON object")
+	}
+	return doc, nil
+}
+
+// signatureMAC returns the HMAC-SHA256 of the key id, timestamp, nonce and canonical JSON of doc
+func signatureMAC(key []byte, sig *Signature, doc map[string]interface{}) ([]byte, error) {
+	canonical, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	for _, part := range []string{sig.KeyId, strconv.FormatInt(sig.Timestamp, 10), sig.Nonce} {
+		mac.Write([]byte(part))
+		mac.Write([]byte{'\n'})
+	}
+	mac.Write(canonical)
+	return mac.Sum(nil), nil
+}
@@ -0,0 +1,152 @@
+package health
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atsu/goat/crypto"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignedReporter(t *testing.T) {
+	pkg, err := crypto.NewScryptPkgWithParams([]byte("secret"), crypto.ScryptParams{N: 1024, R: 8, P: 1, SaltLen: 16, DKLen: 32})
+	assert.NoError(t, err)
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	r.RemoveSink(KafkaSinkName)
+	var buf bytes.Buffer
+	r.AddSink(NewWriterSink("buf", &buf))
+	r.SetSigner(NewScryptSigner("k1", pkg))
+
+	r.SetHealth(Green, "ok")
+	r.AddStat("big", uint64(1<<62+1)) // not representable as a float64
+	r.AddStat("nested", map[string]interface{}{"b": 1.5, "a": "<html>"})
+	r.ReportHealth()
+	payload := bytes.TrimSpace(buf.Bytes())
+
+	decoded, err := crypto.EncodedScryptPkg(pkg.Encode()).Decode()
+	assert.NoError(t, err)
+	v := NewVerifier(time.Minute)
+	v.AddScryptKey("k1", decoded)
+	e, err := v.Verify(payload)
+	assert.NoError(t, err)
+	assert.Equal(t, Green, e.State)
+	assert.Equal(t, "k1", e.Sig.KeyId)
+	assert.InDelta(t, time.Now().Unix(), e.Sig.Timestamp, 1)
+
+	_, err = v.Verify(payload)
+	assert.Equal(t, ErrSignatureReplayed, err)
+
+	// whitespace and key order don't matter, content does
+	buf.Reset()
+	r.ReportHealth()
+	payload = bytes.TrimSpace(buf.Bytes())
+	_, err = v.Verify(bytes.Replace(payload, []byte(`"green"`), []byte(`"red"`), 1))
+	assert.Equal(t, ErrBadSignature, err)
+	_, err = v.Verify(bytes.Replace(payload, []byte(`{"hostname"`), []byte(`{ "hostname"`), 1))
+	assert.NoError(t, err)
+
+	// outside the skew window
+	buf.Reset()
+	r.ReportHealth()
+	v.now = func() time.Time { return time.Now().Add(time.Minute * 2) }
+	_, err = v.Verify(bytes.TrimSpace(buf.Bytes()))
+	assert.Equal(t, ErrSignatureExpired, err)
+
+	v.RemoveKey("k1")
+	_, err = v.Verify(payload)
+	assert.Equal(t, ErrUnknownKey, err)
+	_, err = v.Verify([]byte(`{"service":"test"}`))
+	assert.Equal(t, ErrUnsigned, err)
+	_, err = v.Verify([]byte(`[]`))
+	assert.Error(t, err)
+}
+
+func TestSignedReporterDropsUnsigned(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	r.RemoveSink(KafkaSinkName)
+	var buf bytes.Buffer
+	r.AddSink(NewWriterSink("buf", &buf))
+	errs := make(chan error, 1)
+	r.SetErrFn(func(err error) { errs <- err })
+	r.SetSigner(NewSigner("k1", []byte("key")))
+
+	r.AddStat("nan", math.NaN()) // not encodable, so not signable
+	r.ReportHealth()
+	assert.Empty(t, buf.String())
+	select {
+	case err := <-errs:
+		assert.Contains(t, err.Error(), "event, dropped: json: unsupported value: NaN")
+	case <-time.After(time.Second):
+		t.Fatal("sign error not reported")
+	}
+}
+
+func TestSignedHttpSignal(t *testing.T) {
+	v := NewVerifier(0)
+	v.AddKey("k1", []byte("key"))
+	var got []Event
+	var errs []error
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			e, err := v.Verify([]byte(line))
+			got, errs = append(got, e), append(errs, err)
+		}
+	}))
+	defer srv.Close()
+
+	s := NewHttpSignalWithOptions(srv.URL, HttpSignalOptions{Signer: NewSigner("k1", []byte("key")), BatchSize: 2})
+	assert.NoError(t, s.Report(Event{Service: "svc", State: Green}, map[string]interface{}{"a": 1}))
+	assert.NoError(t, s.Report(Event{Service: "svc", State: Red}, nil))
+	assert.NoError(t, s.Close())
+	assert.Equal(t, []error{nil, nil}, errs)
+	assert.Equal(t, Red, got[1].State)
+}
+
+func TestAggregatorVerifier(t *testing.T) {
+	a := NewAggregator(time.Minute)
+	v := NewVerifier(0)
+	v.AddKey("k1", []byte("key"))
+	a.SetVerifier(v)
+
+	s := NewSigner("k1", []byte("key"))
+	forged := NewSigner("k1", []byte("guess"))
+	for _, signer := range []*Signer{s, forged, nil} {
+		m := healthMessage(t, Event{Service: "api", Hostname: "h1", State: Green, Timestamp: 1})
+		if signer != nil {
+			signed, err := signer.SignBytes(m.Value)
+			assert.NoError(t, err)
+			m = &kafka.Message{TopicPartition: m.TopicPartition, Value: signed}
+		}
+		assert.NoError(t, a.Message(m))
+	}
+	assert.Len(t, a.Instances(), 1)
+	assert.Equal(t, uint64(2), a.Rejected())
+}
+
+func TestVerifierForgetsExpiredSignatures(t *testing.T) {
+	now := time.Now()
+	v := NewVerifier(time.Minute)
+	v.AddKey("k1", []byte("key"))
+	v.now = func() time.Time { return now }
+	s := NewSigner("k1", []byte("key"))
+	s.now = v.now
+
+	for i := 0; i < 10; i++ {
+		b, err := s.SignBytes([]byte(`{"service":"api"}`))
+		assert.NoError(t, err)
+		_, err = v.Verify(b)
+		assert.NoError(t, err)
+		now = now.Add(time.Second * 30)
+	}
+	// only the signatures verified up to 2 minutes ago are kept
+	assert.Len(t, v.seen, 5)
+	assert.Len(t, v.expiry, 5)
+}
@@ -0,0 +1,345 @@
+package health
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// KafkaSinkName is the name of the built-in sink producing to the reporter topic
+const KafkaSinkName = "kafka"
+
+// Sink receives every event reported by a Reporter, payload is the json encoded event
+type Sink interface {
+	Name() string
+	Write(e Event, payload []byte) error
+	Close() error
+}
+
+// SinkStats is the error accounting of a single sink
+type SinkStats struct {
+	Enabled   bool   `json:"enabled"`
+	Sent      uint64 `json:"sent"`
+	Errors    uint64 `json:"errors"`
+	LastError string `json:"last_error,omitempty"`
+	LastErrAt int64  `json:"last_error_at,omitempty"` // unix timestamp
+}
+
+type sinkEntry struct {
+	sink    Sink
+	enabled int32 // atomic bool
+	sent    uint64
+	errors  uint64
+
+	mux       sync.Mutex
+	lastErr   error
+	lastErrAt time.Time
+}
+
+func (se *sinkEntry) write(e Event, payload []byte) error {
+	if atomic.LoadInt32(&se.enabled) == 0 {
+		return nil
+	}
+	err := se.sink.Write(e, payload)
+	if err != nil {
+		atomic.AddUint64(&se.errors, 1)
+		se.mux.Lock()
+		se.lastErr = err
+		se.lastErrAt = time.Now()
+		se.mux.Unlock()
+	} else {
+		atomic.AddUint64(&se.sent, 1)
+	}
+	return err
+}
+
+func (se *sinkEntry) stats() SinkStats {
+	s := SinkStats{
+		Enabled: atomic.LoadInt32(&se.enabled) == 1,
+		Sent:    atomic.LoadUint64(&se.sent),
+		Errors:  atomic.LoadUint64(&se.errors),
+	}
+	se.mux.Lock()
+	defer se.mux.Unlock()
+	if se.lastErr != nil {
+		s.LastError = se.lastErr.Error()
+		s.LastErrAt = se.lastErrAt.Unix()
+	}
+	return s
+}
+
+// AddSink adds an enabled sink, replacing (and closing) any sink with the same name
+func (r *Reporter) AddSink(s Sink) {
+	se := &sinkEntry{sink: s, enabled: 1}
+
+	r.sinkMux.Lock()
+	defer r.sinkMux.Unlock()
+	for i, old := range r.sinks {
+		if old.sink.Name() == s.Name() {
+			r.sinks[i] = se
+			r.closeSink(old.sink)
+			return
+		}
+	}
+	r.sinks = append(r.sinks, se)
+}
+
+// RemoveSink removes and closes the named sink, returning false if there is no such sink
+func (r *Reporter) RemoveSink(name string) bool {
+	r.sinkMux.Lock()
+	defer r.sinkMux.Unlock()
+	for i, se := range r.sinks {
+		if se.sink.Name() == name {
+			r.sinks = append(r.sinks[:i:i], r.sinks[i+1:]...)
+			r.closeSink(se.sink)
+			return true
+		}
+	}
+	return false
+}
+
+// SetSinkEnabled enables or disables the named sink, returning false if there is no such sink
+func (r *Reporter) SetSinkEnabled(name string, enabled bool) bool {
+	r.sinkMux.Lock()
+	defer r.sinkMux.Unlock()
+	for _, se := range r.sinks {
+		if se.sink.Name() == name {
+			var v int32
+			if enabled {
+				v = 1
+			}
+			atomic.StoreInt32(&se.enabled, v)
+			return true
+		}
+	}
+	return false
+}
+
+// SinkStats returns the error accounting of every sink
+func (r *Reporter) SinkStats() map[string]SinkStats {
+	r.sinkMux.Lock()
+	defer r.sinkMux.Unlock()
+	out := make(map[string]SinkStats, len(r.sinks))
+	for _, se := range r.sinks {
+		out[se.sink.Name()] = se.stats()
+	}
+	return out
+}
+
+// emit writes the event to every enabled sink. The kafka sink reports its own errors,
+// errors from any other sink are passed to Errfn.
+func (r *Reporter) emit(e Event, payload []byte) {
+	r.sinkMux.Lock()
+	sinks := append([]*sinkEntry(nil), r.sinks...)
+	r.sinkMux.Unlock()
+
+	for _, se := range sinks {
+		if err := se.write(e, payload); err != nil {
+			if _, ok := se.sink.(*kafkaSink); !ok {
+				r.errorHandler(fmt.Sprintf("sink %s error", se.sink.Name()), err)
+			}
+		}
+	}
+}
+
+// closeSinks closes and removes all sinks
+func (r *Reporter) closeSinks() {
+	r.sinkMux.Lock()
+	defer r.sinkMux.Unlock()
+	for _, se := range r.sinks {
+		r.closeSink(se.sink)
+	}
+	r.sinks = nil
+}
+
+func (r *Reporter) closeSink(s Sink) {
+	r.errorHandler(fmt.Sprintf("could not close sink %s", s.Name()), s.Close())
+}
+
+// kafkaSink produces to the reporter topic, falling back to stdout if enabled with SetStdOutFallback
+type kafkaSink struct {
+	r *Reporter
+}
+
+var _ Sink = &kafkaSink{}
+
+func (k *kafkaSink) Name() string { return KafkaSinkName }
+
+func (k *kafkaSink) Write(_ Event, payload []byte) error {
+	return k.r.produce(k.r.stdOutFallback, k.r.Topic(), payload)
+}
+
+func (k *kafkaSink) Close() error { return nil }
+
+// SignalSink reports events through a Signal, e.g. an HttpSignal
+type SignalSink struct {
+	name   string
+	signal Signal
+}
+
+var _ Sink = &SignalSink{}
+
+func NewSignalSink(name string, s Signal) *SignalSink {
+	return &SignalSink{name: name, signal: s}
+}
+
+func (s *SignalSink) Name() string { return s.name }
+
+func (s *SignalSink) Write(_ Event, payload []byte) error {
+	return s.signal.RawReport(payload)
+}
+
+func (s *SignalSink) Close() error { return nil }
+
+// WriterSink writes each event as a line to an io.Writer
+type WriterSink struct {
+	name string
+	mux  sync.Mutex
+	w    io.Writer
+}
+
+var _ Sink = &WriterSink{}
+
+func NewWriterSink(name string, w io.Writer) *WriterSink {
+	return &WriterSink{name: name, w: w}
+}
+
+// NewStdoutSink writes each event as a line to os.Stdout
+func NewStdoutSink() *WriterSink {
+	return NewWriterSink("stdout", os.Stdout)
+}
+
+func (s *WriterSink) Name() string { return s.name }
+
+func (s *WriterSink) Write(_ Event, payload []byte) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	_, err := s.w.Write(withNewline(payload))
+	return err
+}
+
+func (s *WriterSink) Close() error { return nil }
+
+// FileSink appends each event as a line to a local file, rotating it once it grows beyond maxBytes.
+// Rotated files are named <path>.1 (newest) through <path>.<backups> (oldest).
+type FileSink struct {
+	name     string
+	path     string
+	maxBytes int64
+	backups  int
+
+	mux    sync.Mutex
+	file   *os.File // nil when closed, or when a failed rotation couldn't reopen path
+	size   int64
+	closed bool
+}
+
+var _ Sink = &FileSink{}
+
+// NewFileSink opens (or creates) path for appending, maxBytes < 1 disables rotation
+func NewFileSink(path string, maxBytes int64, backups int) (*FileSink, error) {
+	s := &FileSink{name: "file:" + path, path: path, maxBytes: maxBytes, backups: backups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file = f
+	s.size = fi.Size()
+	return nil
+}
+
+func (s *FileSink) Name() string { return s.name }
+
+// Write appends payload, rotating first if it would exceed maxBytes. When rotation fails the payload is still
+// appended to path if it could be reopened, and rotation is retried on the next write, as is reopening.
+func (s *FileSink) Write(_ Event, payload []byte) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.closed {
+		return fmt.Errorf("file sink %s is closed", s.path)
+	}
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+
+	line := withNewline(payload)
+	var rotateErr error
+	if s.maxBytes > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+		if rotateErr = s.rotate(); s.file == nil {
+			return rotateErr
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return err
+}
+
+// rotate shifts <path>.n to <path>.n+1, dropping the oldest, and starts a new file.
+// Whether or not that succeeds path is reopened, s.file is nil only if that fails too.
+func (s *FileSink) rotate() error {
+	err := s.file.Close()
+	s.file = nil
+	if err == nil {
+		err = s.shift()
+	}
+	if openErr := s.open(); err == nil {
+		err = openErr
+	}
+	return err
+}
+
+// shift moves the closed file to <path>.1, or removes it without backups
+func (s *FileSink) shift() error {
+	if s.backups < 1 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	for i := s.backups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(s.path, s.path+".1")
+}
+
+func (s *FileSink) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.closed = true
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// withNewline returns a copy of payload ending in a newline, payload is shared between sinks
+func withNewline(payload []byte) []byte {
+	line := make([]byte, len(payload), len(payload)+1)
+	copy(line, payload)
+	return append(line, '\n')
+}
@@ -0,0 +1,42 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package health
+
+import (
+	"log/syslog"
+)
+
+// SyslogSink writes each event to the local syslog, with a priority matching the event State
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+var _ Sink = &SyslogSink{}
+
+// NewSyslogSink connects to the local syslog daemon, tag defaults to the program name if empty
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{w: w}, nil
+}
+
+func (s *SyslogSink) Name() string { return "syslog" }
+
+func (s *SyslogSink) Write(e Event, payload []byte) error {
+	msg := string(payload)
+	switch e.State {
+	case Red:
+		return s.w.Err(msg)
+	case Yellow:
+		return s.w.Warning(msg)
+	default:
+		return s.w.Info(msg)
+	}
+}
+
+func (s *SyslogSink) Close() error {
+	return s.w.Close()
+}
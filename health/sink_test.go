@@ -0,0 +1,169 @@
+package health
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failSink always fails to write
+type failSink struct{ closed bool }
+
+func (f *failSink) Name() string              { return "fail" }
+func (f *failSink) Write(Event, []byte) error { return errors.New("nope") }
+func (f *failSink) Close() error              { f.closed = true; return nil }
+
+func TestReporterSinks(t *testing.T) {
+	errs := make(chan error, 10)
+	r, scMock := mockReporter(nil)
+	r.SetErrFn(func(err error) { errs <- err })
+
+	var buf bytes.Buffer
+	fail := &failSink{}
+	r.AddSink(NewWriterSink("buf", &buf))
+	r.AddSink(fail)
+
+	r.SetHealth(Green, "fan out")
+	r.ReportHealth()
+
+	scMock.AssertNumberOfCalls(t, "Produce", 1)
+	var evt Event
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &evt))
+	assert.Equal(t, "fan out", evt.Message)
+	assert.True(t, strings.HasSuffix(buf.String(), "}\n"))
+
+	select {
+	case err := <-errs:
+		assert.EqualError(t, err, "sink fail error: nope")
+	case <-time.After(time.Second):
+		t.Fatal("sink error was not reported")
+	}
+
+	stats := r.SinkStats()
+	assert.Equal(t, SinkStats{Enabled: true, Sent: 1}, stats[KafkaSinkName])
+	assert.Equal(t, SinkStats{Enabled: true, Sent: 1}, stats["buf"])
+	assert.Equal(t, uint64(1), stats["fail"].Errors)
+	assert.Equal(t, "nope", stats["fail"].LastError)
+	assert.NotZero(t, stats["fail"].LastErrAt)
+
+	// disabled sinks are skipped
+	assert.True(t, r.SetSinkEnabled(KafkaSinkName, false))
+	assert.False(t, r.SetSinkEnabled("nope", false))
+	r.ReportHealth()
+	scMock.AssertNumberOfCalls(t, "Produce", 1)
+	assert.Equal(t, uint64(2), r.SinkStats()["buf"].Sent)
+	assert.False(t, r.SinkStats()[KafkaSinkName].Enabled)
+
+	assert.True(t, r.RemoveSink("fail"))
+	assert.False(t, r.RemoveSink("fail"))
+	assert.True(t, fail.closed)
+	assert.Len(t, r.SinkStats(), 2)
+
+	// replacing a sink by name
+	var buf2 bytes.Buffer
+	r.AddSink(NewWriterSink("buf", &buf2))
+	r.ReportHealth()
+	assert.Equal(t, uint64(1), r.SinkStats()["buf"].Sent)
+	assert.NotEmpty(t, buf2.Bytes())
+}
+
+// fakeSignal returns each of errs in turn
+type fakeSignal struct {
+	errs []error
+}
+
+func (f *fakeSignal) Report(Event, interface{}) error { return nil }
+func (f *fakeSignal) RawReport([]byte) error {
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func TestSignalSink(t *testing.T) {
+	sig := &fakeSignal{errs: []error{nil, errors.New("down")}}
+
+	s := NewSignalSink("signal", sig)
+	assert.Equal(t, "signal", s.Name())
+	assert.NoError(t, s.Write(Event{}, []byte("{}")))
+	assert.EqualError(t, s.Write(Event{}, []byte("{}")), "down")
+	assert.NoError(t, s.Close())
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "health.log")
+
+	s, err := NewFileSink(path, 10, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "file:"+path, s.Name())
+	for _, p := range []string{"aaaaaaaa", "bbbbbbbb", "cccccccc", "dddddddd"} {
+		assert.NoError(t, s.Write(Event{}, []byte(p)))
+	}
+	assert.NoError(t, s.Close())
+	assert.Error(t, s.Write(Event{}, []byte("closed")))
+
+	read := func(p string) string {
+		b, _ := ioutil.ReadFile(p)
+		return string(b)
+	}
+	assert.Equal(t, "dddddddd\n", read(path))
+	assert.Equal(t, "cccccccc\n", read(path+".1"))
+	assert.Equal(t, "bbbbbbbb\n", read(path+".2"))
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	// reopening appends, and without backups the file is just truncated
+	s, err = NewFileSink(path, 20, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Write(Event{}, []byte("eeeeeeee")))
+	assert.Equal(t, "dddddddd\neeeeeeee\n", read(path))
+	assert.NoError(t, s.Write(Event{}, []byte("ffffffff")))
+	assert.Equal(t, "ffffffff\n", read(path))
+	assert.NoError(t, s.Close())
+
+	// a failed rotation keeps writing to path and is retried on the next write
+	s, err = NewFileSink(path, 10, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Remove(path+".1"))
+	assert.NoError(t, os.MkdirAll(filepath.Join(path+".1", "busy"), 0755))
+	assert.Error(t, s.Write(Event{}, []byte("gggggggg")))
+	assert.Equal(t, "ffffffff\ngggggggg\n", read(path))
+	assert.NoError(t, os.RemoveAll(path+".1"))
+	assert.NoError(t, s.Write(Event{}, []byte("hhhhhhhh")))
+	assert.Equal(t, "hhhhhhhh\n", read(path))
+	assert.Equal(t, "ffffffff\ngggggggg\n", read(path+".1"))
+
+	// and so is reopening path
+	s.mux.Lock()
+	s.file.Close()
+	s.file = nil
+	s.mux.Unlock()
+	assert.NoError(t, s.Write(Event{}, []byte("iiiiiiii")))
+	assert.Equal(t, "iiiiiiii\n", read(path))
+	assert.Equal(t, "hhhhhhhh\n", read(path+".1"))
+	assert.NoError(t, s.Close())
+	assert.Error(t, s.Write(Event{}, []byte("closed")))
+}
+
+func TestSyslogSink(t *testing.T) {
+	s, err := NewSyslogSink("goat-test")
+	if err != nil {
+		t.Skip("syslog not available:", err)
+	}
+	assert.Equal(t, "syslog", s.Name())
+	assert.NoError(t, s.Write(Event{State: Red}, []byte("{}")))
+	assert.NoError(t, s.Write(Event{State: Green}, []byte("{}")))
+	assert.NoError(t, s.Close())
+}
@@ -0,0 +1,415 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SLODataKey is the Event.Data key holding the SLOStatus of every objective
+const SLODataKey = "slo"
+
+// DefaultSLOSaveInterval is the minimum time between two saves of a persisted SLOTracker
+const DefaultSLOSaveInterval = time.Minute
+
+// The rolling windows reported for every objective
+const (
+	SLOWindowHour      = time.Hour
+	SLOWindowSixHours  = time.Hour * 6
+	SLOWindowThirtyDay = time.Hour * 24 * 30
+)
+
+var sloWindows = []struct {
+	name string
+	d    time.Duration
+}{{"1h", SLOWindowHour}, {"6h", SLOWindowSixHours}, {"30d", SLOWindowThirtyDay}}
+
+// DefaultBurnAlerts page on a fast burn, which spends 2% of a 30 day budget in an hour,
+// and warn on a slow burn, which spends 5% in 6 hours.
+var DefaultBurnAlerts = []BurnAlert{
+	{Name: "fast", Long: time.Hour, Short: time.Minute * 5, Rate: 14.4, State: Red},
+	{Name: "slow", Long: time.Hour * 6, Short: time.Minute * 30, Rate: 6, State: Yellow},
+}
+
+// SLObjective is a named objective, e.g. 99.9% of requests served under 200ms
+type SLObjective struct {
+	Name    string
+	Target  float64       // fraction of good events, e.g. 0.999
+	Latency time.Duration // events slower than this are bad, only used by RecordLatency
+}
+
+// BurnAlert fires when the error budget burn rate is at least Rate over both the Long and Short windows,
+// so it fires quickly and stops soon after the burn does. Windows can be at most 6h.
+type BurnAlert struct {
+	Name  string
+	Long  time.Duration
+	Short time.Duration
+	Rate  float64 // 1 spends exactly the budget over the SLO period
+	State State   // reported while firing
+}
+
+// SLOOptions configures an SLOTracker, zero values mean the defaults
+type SLOOptions struct {
+	Alerts       []BurnAlert   // DefaultBurnAlerts if nil
+	Path         string        // file the counts are loaded from and saved to, not persisted if empty
+	SaveInterval time.Duration // minimum time between saves when reporting, DefaultSLOSaveInterval if 0
+}
+
+// SLOWindow are the counts of an objective over a rolling window.
+// The budget is that of the whole SLO period, so it is computed from the 30d counts in every window.
+type SLOWindow struct {
+	Good       uint64  `json:"good"`
+	Bad        uint64  `json:"bad"`
+	Attainment float64 `json:"attainment"` // fraction of good events, 1 without events
+	BurnRate   float64 `json:"burn_rate"`  // fraction of bad events over the error budget
+	Budget     float64 `json:"budget"`     // fraction of the 30d error budget remaining, negative once overspent
+}
+
+// SLOStatus is the status of an objective as reported in Event.Data
+type SLOStatus struct {
+	Target  float64              `json:"target"`
+	Latency int64                `json:"latency_ms,omitempty"`
+	Windows map[string]SLOWindow `json:"windows"` // keyed by 1h, 6h and 30d
+	Firing  []string             `json:"firing,omitempty"`
+	State   State                `json:"state,omitempty"` // the worst firing alert
+	Message string               `json:"msg,omitempty"`
+}
+
+// sloBucket counts the events starting at Start (unix timestamp)
+type sloBucket struct {
+	Start int64  `json:"start"`
+	Good  uint64 `json:"good"`
+	Bad   uint64 `json:"bad"`
+}
+
+// sloRing is a ring of fixed width buckets
+type sloRing struct {
+	width   time.Duration
+	buckets []sloBucket
+}
+
+type sloCounts struct {
+	objective SLObjective
+	minutes   sloRing // 6h, for the 1h, 6h and alert windows
+	hours     sloRing // 30d
+}
+
+// SLOTracker keeps rolling window counts of good and bad events against named objectives
+type SLOTracker struct {
+	opts SLOOptions
+	now  func() time.Time
+
+	mux        sync.Mutex
+	objectives map[string]*sloCounts
+	saved      time.Time
+	loaded     map[string]sloFileCounts // persisted counts of objectives not added yet
+}
+
+// sloFileCounts is the persisted form of sloCounts
+type sloFileCounts struct {
+	Minutes []sloBucket `json:"minutes"`
+	Hours   []sloBucket `json:"hours"`
+}
+
+// NewSLOTracker creates a tracker, loading the counts from opts.Path if it exists
+func NewSLOTracker(opts SLOOptions) (*SLOTracker, error) {
+	if opts.Alerts == nil {
+		opts.Alerts = DefaultBurnAlerts
+	}
+	for _, a := range opts.Alerts {
+		if a.Long <= 0 || a.Short <= 0 || a.Long > SLOWindowSixHours || a.Short > SLOWindowSixHours || a.Rate <= 0 {
+			return nil, fmt.Errorf("burn alert %s: windows must be within (0, 6h] and rate positive", a.Name)
+		}
+	}
+	if opts.SaveInterval <= 0 {
+		opts.SaveInterval = DefaultSLOSaveInterval
+	}
+	t := &SLOTracker{opts: opts, now: time.Now, objectives: make(map[string]*sloCounts)}
+	if opts.Path == "" {
+		return t, nil
+	}
+	b, err := ioutil.ReadFile(opts.Path)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &t.loaded); err != nil {
+		return nil, fmt.Errorf("could not load slo counts from %s: %v", opts.Path, err)
+	}
+	return t, nil
+}
+
+// AddObjective adds (or replaces the target of) a named objective, keeping any counts loaded or recorded for it
+func (t *SLOTracker) AddObjective(o SLObjective) error {
+	if o.Target <= 0 || o.Target >= 1 {
+		return fmt.Errorf("slo %s: target must be within (0, 1)", o.Name)
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if c, ok := t.objectives[o.Name]; ok {
+		c.objective = o
+		return nil
+	}
+	c := &sloCounts{
+		objective: o,
+		minutes:   sloRing{width: time.Minute, buckets: make([]sloBucket, int(SLOWindowSixHours/time.Minute))},
+		hours:     sloRing{width: time.Hour, buckets: make([]sloBucket, int(SLOWindowThirtyDay/time.Hour))},
+	}
+	if saved, ok := t.loaded[o.Name]; ok {
+		c.minutes.restore(saved.Minutes)
+		c.hours.restore(saved.Hours)
+		delete(t.loaded, o.Name)
+	}
+	t.objectives[o.Name] = c
+	return nil
+}
+
+// RemoveObjective removes a named objective and its counts
+func (t *SLOTracker) RemoveObjective(name string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	delete(t.objectives, name)
+}
+
+// Record counts a good or bad event against a named objective
+func (t *SLOTracker) Record(name string, good bool) {
+	now := t.now()
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	c, ok := t.objectives[name]
+	if !ok {
+		fmt.Printf("[warn] event for unknown slo %s is ignored.\n", name)
+		return
+	}
+	c.minutes.add(now, good)
+	c.hours.add(now, good)
+}
+
+// RecordLatency counts an event against a named objective, good if d is within the objective latency
+func (t *SLOTracker) RecordLatency(name string, d time.Duration) {
+	t.mux.Lock()
+	c, ok := t.objectives[name]
+	var latency time.Duration
+	if ok {
+		latency = c.objective.Latency
+	}
+	t.mux.Unlock()
+	t.Record(name, d <= latency)
+}
+
+// Statuses returns the status of every objective
+func (t *SLOTracker) Statuses() map[string]SLOStatus {
+	now := t.now()
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	out := make(map[string]SLOStatus, len(t.objectives))
+	for name, c := range t.objectives {
+		out[name] = c.status(now, t.opts.Alerts)
+	}
+	return out
+}
+
+// Register reports the status of every objective with r under SLODataKey, degrading r while a burn alert fires.
+// When persisted, the counts are saved on reports at most every SaveInterval and on Stop,
+// errors are passed to Errfn.
+func (t *SLOTracker) Register(r *Reporter) {
+	r.mux.Lock()
+	r.slo = t
+	r.mux.Unlock()
+	if t.opts.Path == "" {
+		return
+	}
+	r.RegisterStatFn(SLODataKey, func(IReporter) {
+		t.mux.Lock()
+		due := t.now().Sub(t.saved) >= t.opts.SaveInterval
+		t.mux.Unlock()
+		if due {
+			r.errorHandler("could not save slo counts", t.Save())
+		}
+	})
+}
+
+// saveSLO saves the counts of the registered tracker, if it is persisted
+func (r *Reporter) saveSLO() {
+	r.mux.Lock()
+	t := r.slo
+	r.mux.Unlock()
+	if t != nil && t.opts.Path != "" {
+		r.errorHandler("could not save slo counts", t.Save())
+	}
+}
+
+// Save writes the counts to the tracker path, replacing the file atomically
+func (t *SLOTracker) Save() error {
+	if t.opts.Path == "" {
+		return fmt.Errorf("slo tracker has no path")
+	}
+	now := t.now()
+	t.mux.Lock()
+	out := make(map[string]sloFileCounts, len(t.objectives)+len(t.loaded))
+	for name, saved := range t.loaded {
+		out[name] = saved
+	}
+	for name, c := range t.objectives {
+		out[name] = sloFileCounts{Minutes: c.minutes.active(now), Hours: c.hours.active(now)}
+	}
+	t.saved = now
+	t.mux.Unlock()
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(t.opts.Path), filepath.Base(t.opts.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(b); err == nil {
+		err = tmp.Close()
+	} else {
+		_ = tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), t.opts.Path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (c *sloCounts) status(now time.Time, alerts []BurnAlert) SLOStatus {
+	o := c.objective
+	s := SLOStatus{Target: o.Target, Latency: int64(o.Latency / time.Millisecond), Windows: make(map[string]SLOWindow)}
+	budget := c.window(now, SLOWindowThirtyDay).Budget
+	for _, w := range sloWindows {
+		win := c.window(now, w.d)
+		win.Budget = budget
+		s.Windows[w.name] = win
+	}
+
+	var reasons []string
+	for _, a := range alerts {
+		long, short := c.window(now, a.Long).BurnRate, c.window(now, a.Short).BurnRate
+		if long < a.Rate || short < a.Rate {
+			continue
+		}
+		s.Firing = append(s.Firing, a.Name)
+		if a.State.Worse(s.State) || s.State == "" {
+			s.State = a.State
+			reasons = reasons[:0]
+		}
+		if a.State == s.State {
+			reasons = append(reasons, fmt.Sprintf("burn rate %.1fx over %s", long, a.Long))
+		}
+	}
+	if len(reasons) > 0 {
+		s.Message = strings.Join(reasons, ", ")
+	}
+	return s
+}
+
+func (c *sloCounts) window(now time.Time, d time.Duration) SLOWindow {
+	ring := &c.minutes
+	if d > SLOWindowSixHours {
+		ring = &c.hours
+	}
+	good, bad := ring.sum(now, d)
+	w := SLOWindow{Good: good, Bad: bad, Attainment: 1, Budget: 1}
+	if total := good + bad; total > 0 {
+		errRate := float64(bad) / float64(total)
+		w.Attainment = 1 - errRate
+		w.BurnRate = errRate / (1 - c.objective.Target)
+		w.Budget = 1 - w.BurnRate // of d, only the SLO budget when d is the whole period
+	}
+	return w
+}
+
+func (r *sloRing) index(start int64) int {
+	return int(start/int64(r.width/time.Second)) % len(r.buckets)
+}
+
+func (r *sloRing) add(now time.Time, good bool) {
+	start := now.Truncate(r.width).Unix()
+	b := &r.buckets[r.index(start)]
+	if b.Start != start {
+		*b = sloBucket{Start: start}
+	}
+	if good {
+		b.Good++
+	} else {
+		b.Bad++
+	}
+}
+
+// sum returns the counts of the buckets overlapping the d before now
+func (r *sloRing) sum(now time.Time, d time.Duration) (good, bad uint64) {
+	from, to, width := now.Add(-d).Unix(), now.Unix(), int64(r.width/time.Second)
+	for _, b := range r.buckets {
+		if b.Start+width > from && b.Start <= to {
+			good += b.Good
+			bad += b.Bad
+		}
+	}
+	return good, bad
+}
+
+// active returns the buckets within the ring span of now, oldest first
+func (r *sloRing) active(now time.Time) []sloBucket {
+	from := now.Add(-r.width * time.Duration(len(r.buckets))).Unix()
+	var out []sloBucket
+	for _, b := range r.buckets {
+		if b.Start > from && (b.Good > 0 || b.Bad > 0) {
+			out = append(out, b)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start < out[j].Start })
+	return out
+}
+
+func (r *sloRing) restore(buckets []sloBucket) {
+	for _, b := range buckets {
+		cur := &r.buckets[r.index(b.Start)]
+		if b.Start > cur.Start { // the newest bucket wins its slot
+			*cur = b
+		}
+	}
+}
+
+// aggregateSLO returns the worst of state and all firing burn alerts, along with the message to report.
+// When an objective is worse than state, the message names it and its burn rates.
+func aggregateSLO(state State, message string, statuses map[string]SLOStatus) (State, string) {
+	names := make([]string, 0, len(statuses))
+	for name := range statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var burning []string
+	worst := state
+	for _, name := range names {
+		s := statuses[name]
+		if len(s.Firing) == 0 {
+			continue
+		}
+		if s.State.Worse(worst) {
+			worst = s.State
+			burning = burning[:0]
+		}
+		if s.State == worst && worst != state {
+			burning = append(burning, fmt.Sprintf("slo %s %s", name, s.Message))
+		}
+	}
+	if worst == state {
+		return state, message
+	}
+	return worst, strings.Join(burning, "; ")
+}
@@ -0,0 +1,153 @@
+package health
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// clock returns a settable time source starting at an hour boundary
+func clock() (func() time.Time, func(time.Duration)) {
+	now := time.Unix(1600000000, 0).Truncate(time.Hour)
+	return func() time.Time { return now }, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestSLOTracker(t *testing.T) {
+	tr, err := NewSLOTracker(SLOOptions{})
+	assert.NoError(t, err)
+	now, advance := clock()
+	tr.now = now
+	assert.Error(t, tr.AddObjective(SLObjective{Name: "api", Target: 1}))
+	assert.NoError(t, tr.AddObjective(SLObjective{Name: "api", Target: 0.99, Latency: time.Millisecond * 200}))
+
+	// a day of 1% errors, exactly on budget
+	for i := 0; i < 24*60; i++ {
+		for j := 0; j < 99; j++ {
+			tr.RecordLatency("api", time.Millisecond*100)
+		}
+		tr.RecordLatency("api", time.Second)
+		advance(time.Minute)
+	}
+	tr.Record("unknown", true)
+
+	s := tr.Statuses()["api"]
+	assert.Equal(t, int64(200), s.Latency)
+	day := s.Windows["30d"]
+	assert.Equal(t, uint64(24*60*99), day.Good)
+	assert.Equal(t, uint64(24*60), day.Bad)
+	assert.InDelta(t, 0.99, day.Attainment, 1e-9)
+	assert.InDelta(t, 1, day.BurnRate, 1e-9)
+	assert.InDelta(t, 0, day.Budget, 1e-9)
+	assert.Equal(t, uint64(60*99), s.Windows["1h"].Good)
+	assert.Equal(t, uint64(6*60), s.Windows["6h"].Bad)
+	assert.Empty(t, s.Firing)
+
+	// the budget is that of the 30d period in every window
+	for i := 0; i < 60; i++ {
+		tr.Record("api", false)
+	}
+	advance(time.Minute)
+	s = tr.Statuses()["api"]
+	assert.InDelta(t, -60.0/(24*60), s.Windows["30d"].Budget, 0.01)
+	assert.Equal(t, s.Windows["30d"].Budget, s.Windows["1h"].Budget)
+	assert.True(t, s.Windows["1h"].BurnRate > 1)
+
+	// 10% errors burns 10x, firing the slow alert once the 6h rate catches up
+	for i := 0; i < 6*60; i++ {
+		for j := 0; j < 9; j++ {
+			tr.Record("api", true)
+		}
+		tr.Record("api", false)
+		advance(time.Minute)
+	}
+	s = tr.Statuses()["api"]
+	assert.Equal(t, []string{"slow"}, s.Firing)
+	assert.Equal(t, Yellow, s.State)
+	assert.Equal(t, "burn rate 10.0x over 6h0m0s", s.Message)
+
+	// all errors for 5 minutes fires the fast alert too, ten minutes later the short window clears it
+	for i := 0; i < 60; i++ {
+		tr.Record("api", false)
+		advance(time.Second * 5)
+	}
+	s = tr.Statuses()["api"]
+	assert.Equal(t, []string{"fast", "slow"}, s.Firing)
+	assert.Equal(t, Red, s.State)
+	advance(time.Minute * 10)
+	assert.Equal(t, []string{"slow"}, tr.Statuses()["api"].Firing)
+}
+
+func TestReporterSLO(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	r.SetHealth(Green, "ok")
+	tr, err := NewSLOTracker(SLOOptions{Alerts: []BurnAlert{{Name: "page", Long: time.Hour, Short: time.Minute, Rate: 2, State: Red}}})
+	assert.NoError(t, err)
+	assert.NoError(t, tr.AddObjective(SLObjective{Name: "api", Target: 0.9}))
+	tr.Register(r)
+
+	tr.Record("api", true)
+	h := r.Health()
+	assert.Equal(t, Green, h.State)
+	assert.Contains(t, h.Data.(map[string]interface{})[SLODataKey], "api")
+
+	tr.Record("api", false)
+	h = r.Health()
+	assert.Equal(t, Red, h.State)
+	assert.Equal(t, "slo api burn rate 5.0x over 1h0m0s", h.Message)
+
+	_, err = NewSLOTracker(SLOOptions{Alerts: []BurnAlert{{Name: "month", Long: SLOWindowThirtyDay, Short: time.Hour, Rate: 1}}})
+	assert.Error(t, err)
+}
+
+func TestSLOTrackerPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slo")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "slo.json")
+	now, advance := clock()
+
+	tr, err := NewSLOTracker(SLOOptions{Path: path})
+	assert.NoError(t, err)
+	tr.now = now
+	assert.NoError(t, tr.AddObjective(SLObjective{Name: "api", Target: 0.99}))
+	assert.NoError(t, tr.AddObjective(SLObjective{Name: "jobs", Target: 0.9}))
+	tr.Record("api", true)
+	advance(time.Hour * 2)
+	tr.Record("api", false)
+	tr.Record("jobs", true)
+
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	r.RemoveSink(KafkaSinkName)
+	tr.Register(r)
+	r.ReportHealth() // saves
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+	tr.Record("jobs", false)
+	assert.NoError(t, r.Stop()) // saves again, whatever the interval
+
+	loaded, err := NewSLOTracker(SLOOptions{Path: path})
+	assert.NoError(t, err)
+	loaded.now = now
+	assert.NoError(t, loaded.AddObjective(SLObjective{Name: "api", Target: 0.99}))
+	api := loaded.Statuses()["api"]
+	assert.Equal(t, uint64(1), api.Windows["1h"].Bad)
+	assert.InDelta(t, 100, api.Windows["1h"].BurnRate, 1e-9)
+	assert.Equal(t, uint64(1), api.Windows["30d"].Good)
+
+	// counts of objectives not added yet are kept on save
+	assert.NoError(t, loaded.Save())
+	again, err := NewSLOTracker(SLOOptions{Path: path})
+	assert.NoError(t, err)
+	again.now = now
+	assert.NoError(t, again.AddObjective(SLObjective{Name: "jobs", Target: 0.9}))
+	assert.Equal(t, uint64(1), again.Statuses()["jobs"].Windows["1h"].Good)
+	assert.Equal(t, uint64(1), again.Statuses()["jobs"].Windows["1h"].Bad)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("nope"), 0644))
+	_, err = NewSLOTracker(SLOOptions{Path: path})
+	assert.Error(t, err)
+}
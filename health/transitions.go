@@ -0,0 +1,128 @@
+package health
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/atsu/goat/util"
+)
+
+// Event names
+const (
+	EventStatus     = "status"     // periodic report, the state has not changed
+	EventStartup    = "startup"    // first report of a reporter
+	EventShutdown   = "shutdown"   // final report, see StopWithFinalState
+	EventTransition = "transition" // the state changed since the last report
+)
+
+// DefaultTransitionHistory is the number of transitions kept, see SetTransitionHistory
+const DefaultTransitionHistory = 100
+
+// TransitionDataKey is the Event.Data key holding the Transition of a startup, shutdown or transition event
+const TransitionDataKey = "transition"
+
+// Transition is a change of the reported state
+type Transition struct {
+	Event    string `json:"event"`          // name of the event that reported the change
+	From     State  `json:"from,omitempty"` // empty on startup
+	To       State  `json:"to"`
+	At       int64  `json:"at"`          // unix timestamp
+	Duration int64  `json:"duration_ms"` // time spent in From
+	Reason   string `json:"reason"`      // message reported with To
+}
+
+// transitionLog tracks the reported state, it is separate from Reporter.mux since it is used
+// after Health() has released it
+type transitionLog struct {
+	mux     sync.Mutex
+	started bool
+	state   State
+	since   time.Time
+	history []Transition // oldest first
+	max     int
+}
+
+// observe names the event about to be reported, and records a Transition if its state changed
+func (tl *transitionLog) observe(e *Event, name string, now time.Time) {
+	tl.mux.Lock()
+	defer tl.mux.Unlock()
+
+	if !tl.started && name == EventStatus {
+		name = EventStartup
+	}
+	if tl.started && e.State == tl.state && name != EventStartup { // a restart is always recorded
+		e.Name = name
+		return
+	}
+
+	t := Transition{Event: name, To: e.State, At: now.Unix(), Reason: e.Message}
+	if tl.started {
+		t.From = tl.state
+		t.Duration = int64(now.Sub(tl.since) / time.Millisecond)
+		if name == EventStatus {
+			name = EventTransition
+			t.Event = name
+		}
+	}
+	tl.started = true
+	tl.state = e.State
+	tl.since = now
+
+	tl.history = append(tl.history, t)
+	if over := len(tl.history) - tl.max; over > 0 {
+		tl.history = append(tl.history[:0:0], tl.history[over:]...)
+	}
+
+	e.Name = name
+	if data, ok := e.Data.(map[string]interface{}); ok {
+		data[TransitionDataKey] = t
+	}
+}
+
+// last returns the last reported state, false if nothing was reported yet
+func (tl *transitionLog) last() (State, bool) {
+	tl.mux.Lock()
+	defer tl.mux.Unlock()
+	return tl.state, tl.started
+}
+
+// SetTransitionHistory sets the number of transitions kept, DefaultTransitionHistory if n < 1
+func (r *Reporter) SetTransitionHistory(n int) {
+	if n < 1 {
+		n = DefaultTransitionHistory
+	}
+	tl := &r.transitions
+	tl.mux.Lock()
+	defer tl.mux.Unlock()
+	tl.max = n
+	if over := len(tl.history) - n; over > 0 {
+		tl.history = append(tl.history[:0:0], tl.history[over:]...)
+	}
+}
+
+// Transitions returns the recorded transitions, oldest first
+func (r *Reporter) Transitions() []Transition {
+	tl := &r.transitions
+	tl.mux.Lock()
+	defer tl.mux.Unlock()
+	return append([]Transition(nil), tl.history...)
+}
+
+// TransitionsHandler returns the transition history, newest first.
+// Use the `limit` query parameter to return only the most recent transitions.
+func (r *Reporter) TransitionsHandler(w http.ResponseWriter, req *http.Request) {
+	history := r.Transitions()
+	out := make([]Transition, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		out = append(out, history[i])
+	}
+	if limit, err := strconv.Atoi(req.URL.Query().Get("limit")); err == nil && limit >= 0 && limit < len(out) {
+		out = out[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err := w.Write(util.MarshalWithPretty(req, out))
+	r.errorHandler("could not write transitions response", err)
+}
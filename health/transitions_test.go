@@ -0,0 +1,107 @@
+package health
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sinkEvents decodes every event written to a WriterSink buffer
+func sinkEvents(t *testing.T, buf *bytes.Buffer) []Event {
+	var out []Event
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var e Event
+		assert.NoError(t, json.Unmarshal([]byte(line), &e))
+		out = append(out, e)
+	}
+	return out
+}
+
+func TestReporterTransitions(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	r.RemoveSink(KafkaSinkName)
+	var buf bytes.Buffer
+	r.AddSink(NewWriterSink("buf", &buf))
+
+	r.SetHealth(Green, "up")
+	r.ReportHealth()
+	r.ReportHealth()
+	r.SetHealth(Red, "db down")
+	r.ReportHealth()
+	r.SetHealth(Red, "still down")
+	r.ReportHealth()
+	r.SetHealth(Gray, "bye")
+	r.report(EventShutdown)
+
+	events := sinkEvents(t, &buf)
+	names := make([]string, 0, len(events))
+	for _, e := range events {
+		names = append(names, e.Name)
+	}
+	assert.Equal(t, []string{EventStartup, EventStatus, EventTransition, EventStatus, EventShutdown}, names)
+
+	// only events that changed state carry the transition
+	_, ok := events[1].Data.(map[string]interface{})[TransitionDataKey]
+	assert.False(t, ok)
+	tr := events[2].Data.(map[string]interface{})[TransitionDataKey].(map[string]interface{})
+	assert.Equal(t, "green", tr["from"])
+	assert.Equal(t, "red", tr["to"])
+	assert.Equal(t, "db down", tr["reason"])
+
+	history := r.Transitions()
+	assert.Len(t, history, 3)
+	assert.Equal(t, Transition{Event: EventStartup, To: Green, At: history[0].At, Reason: "up"}, history[0])
+	assert.Equal(t, Green, history[1].From)
+	assert.Equal(t, EventShutdown, history[2].Event)
+	assert.Equal(t, Red, history[2].From)
+	assert.Equal(t, Gray, history[2].To)
+}
+
+func TestTransitionLog(t *testing.T) {
+	tl := transitionLog{max: 2}
+	now := time.Unix(1000, 0)
+	report := func(state State, after time.Duration) Event {
+		now = now.Add(after)
+		e := Event{State: state, Data: map[string]interface{}{}}
+		tl.observe(&e, EventStatus, now)
+		return e
+	}
+
+	assert.Equal(t, EventStartup, report(Green, 0).Name)
+	assert.Equal(t, EventTransition, report(Yellow, time.Second*3).Name)
+	assert.Equal(t, EventTransition, report(Red, time.Millisecond*1500).Name)
+
+	// bounded, oldest dropped
+	assert.Len(t, tl.history, 2)
+	assert.Equal(t, Green, tl.history[0].From)
+	assert.Equal(t, int64(3000), tl.history[0].Duration)
+	assert.Equal(t, Yellow, tl.history[1].From)
+	assert.Equal(t, int64(1500), tl.history[1].Duration)
+}
+
+func TestTransitionsHandler(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	r.RemoveSink(KafkaSinkName)
+	r.SetTransitionHistory(10)
+	for _, s := range []State{Green, Yellow, Red} {
+		r.SetHealth(s, "")
+		r.ReportHealth()
+	}
+
+	rec := httptest.NewRecorder()
+	r.TransitionsHandler(rec, httptest.NewRequest("GET", "/transitions?limit=2", nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var got []Transition
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Len(t, got, 2)
+	assert.Equal(t, Red, got[0].To) // newest first
+	assert.Equal(t, Yellow, got[1].To)
+
+	r.SetTransitionHistory(1)
+	assert.Len(t, r.Transitions(), 1)
+}
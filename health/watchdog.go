@@ -0,0 +1,107 @@
+package health
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// WatchdogsDataKey is the Event.Data key holding the per watchdog status
+const WatchdogsDataKey = "watchdogs"
+
+// WatchdogStatus is the status of a watchdog as reported in Event.Data
+type WatchdogStatus struct {
+	Deadline  int64 `json:"deadline_ms"`
+	LastKick  int64 `json:"last_kick"`     // unix timestamp
+	SinceKick int64 `json:"since_kick_ms"` // time since the last kick
+	Expired   bool  `json:"expired"`
+	State     State `json:"state"` // the state reported while expired
+}
+
+type watchdog struct {
+	deadline time.Duration
+	state    State
+	lastKick int64 // unix nano, atomic
+}
+
+// RegisterWatchdog registers (or replaces) a named watchdog, which must be kicked with Kick at least
+// once per deadline. When it isn't, the reporter degrades to state until the next kick.
+// Registering counts as a kick.
+func (r *Reporter) RegisterWatchdog(name string, deadline time.Duration, state State) {
+	r.watchdogs.Store(name, &watchdog{deadline: deadline, state: state, lastKick: time.Now().UnixNano()})
+	r.unknownKicks.Delete(name)
+}
+
+// UnregisterWatchdog removes a named watchdog
+func (r *Reporter) UnregisterWatchdog(name string) {
+	r.watchdogs.Delete(name)
+}
+
+// Kick resets the deadline of a named watchdog, kicks of an unregistered watchdog are ignored
+// with a warning the first time
+func (r *Reporter) Kick(name string) {
+	w, ok := r.watchdogs.Load(name)
+	if !ok {
+		if _, warned := r.unknownKicks.LoadOrStore(name, true); !warned {
+			fmt.Printf("[warn] kick of unregistered watchdog %s is ignored.\n", name)
+		}
+		return
+	}
+	atomic.StoreInt64(&w.(*watchdog).lastKick, time.Now().UnixNano())
+}
+
+// WatchdogStatuses returns the status of every registered watchdog
+func (r *Reporter) WatchdogStatuses() map[string]WatchdogStatus {
+	now := time.Now()
+	out := make(map[string]WatchdogStatus)
+	r.watchdogs.Range(func(k, v interface{}) bool {
+		out[k.(string)] = v.(*watchdog).status(now)
+		return true
+	})
+	return out
+}
+
+func (w *watchdog) status(now time.Time) WatchdogStatus {
+	last := time.Unix(0, atomic.LoadInt64(&w.lastKick))
+	since := now.Sub(last)
+	return WatchdogStatus{
+		Deadline:  int64(w.deadline / time.Millisecond),
+		LastKick:  last.Unix(),
+		SinceKick: int64(since / time.Millisecond),
+		Expired:   since > w.deadline,
+		State:     w.state,
+	}
+}
+
+// aggregateWatchdogs returns the worst of state and all expired watchdogs, along with the message to report.
+// When a watchdog is worse than state, the message names it and the time since its last kick.
+func aggregateWatchdogs(state State, message string, statuses map[string]WatchdogStatus) (State, string) {
+	names := make([]string, 0, len(statuses))
+	for name := range statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var stuck []string
+	worst := state
+	for _, name := range names {
+		s := statuses[name]
+		if !s.Expired {
+			continue
+		}
+		if s.State.Worse(worst) {
+			worst = s.State
+			stuck = stuck[:0]
+		}
+		if s.State == worst && worst != state {
+			since := time.Duration(s.SinceKick) * time.Millisecond
+			stuck = append(stuck, fmt.Sprintf("watchdog %s not kicked for %s", name, since.Round(time.Second)))
+		}
+	}
+	if worst == state {
+		return state, message
+	}
+	return worst, strings.Join(stuck, "; ")
+}
@@ -0,0 +1,71 @@
+package health
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// expire backdates the last kick of a watchdog
+func expire(r *Reporter, name string, ago time.Duration) {
+	w, _ := r.watchdogs.Load(name)
+	atomic.StoreInt64(&w.(*watchdog).lastKick, time.Now().Add(-ago).UnixNano())
+}
+
+func TestReporterWatchdogs(t *testing.T) {
+	r := NewReporter("test", "test", "0.0.0.0:9092", nil)
+	r.SetHealth(Green, "ok")
+	r.RegisterWatchdog("consumer", time.Minute, Red)
+	r.RegisterWatchdog("cleanup", time.Minute, Yellow)
+
+	h := r.Health()
+	assert.Equal(t, Green, h.State)
+	statuses := h.Data.(map[string]interface{})[WatchdogsDataKey].(map[string]WatchdogStatus)
+	assert.Len(t, statuses, 2)
+	assert.False(t, statuses["consumer"].Expired)
+	assert.Equal(t, int64(60000), statuses["consumer"].Deadline)
+
+	expire(r, "cleanup", time.Minute*2)
+	h = r.Health()
+	assert.Equal(t, Yellow, h.State)
+	assert.Equal(t, "watchdog cleanup not kicked for 2m0s", h.Message)
+
+	// the worst expired watchdog wins
+	expire(r, "consumer", time.Second*90)
+	h = r.Health()
+	assert.Equal(t, Red, h.State)
+	assert.Equal(t, "watchdog consumer not kicked for 1m30s", h.Message)
+
+	// and kicks recover
+	r.Kick("consumer")
+	r.Kick("cleanup")
+	r.Kick("unknown")
+	r.Kick("unknown") // only warned about once
+	_, warned := r.unknownKicks.Load("unknown")
+	assert.True(t, warned)
+	h = r.Health()
+	assert.Equal(t, Green, h.State)
+	assert.Equal(t, "ok", h.Message)
+
+	r.UnregisterWatchdog("consumer")
+	r.UnregisterWatchdog("cleanup")
+	_, ok := r.Health().Data.(map[string]interface{})[WatchdogsDataKey]
+	assert.False(t, ok)
+}
+
+func TestAggregateWatchdogs(t *testing.T) {
+	statuses := map[string]WatchdogStatus{
+		"a": {Expired: true, State: Yellow, SinceKick: 1000},
+		"b": {Expired: true, State: Yellow, SinceKick: 2000},
+	}
+	state, msg := aggregateWatchdogs(Green, "ok", statuses)
+	assert.Equal(t, Yellow, state)
+	assert.Equal(t, "watchdog a not kicked for 1s; watchdog b not kicked for 2s", msg)
+
+	// a worse manual state is kept
+	state, msg = aggregateWatchdogs(Red, "down", statuses)
+	assert.Equal(t, Red, state)
+	assert.Equal(t, "down", msg)
+}
@@ -0,0 +1,131 @@
+package stream
+
+import (
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// DefaultConsumeBuffer is the number of messages Consume buffers when StreamConfig.Buffer is unset
+const DefaultConsumeBuffer = 1000
+
+// BackPressureConsumer may be implemented by a StreamConsumer that needs to slow Consume down.
+// While busy no messages are delivered, and once the internal buffer fills (or immediately, if busy)
+// the assigned partitions are paused until the consumer reports capacity again.
+//
+// Consume reads the channel on the goroutine calling Message and Process. A buffered channel is read
+// between messages, so sending from Message takes effect before the next one, as long as the buffer has
+// room. An unbuffered channel is read by a separate goroutine, so sending from Message or Process doesn't
+// block, but may only take effect a message later.
+type BackPressureConsumer interface {
+	PressureCh() <-chan bool // true when busy, false when there is capacity again
+}
+
+// relayPressure returns ch if it is buffered, or a buffered channel receiving the latest value sent on ch
+// until it is closed or done is, so that sending on ch never waits for Consume
+func relayPressure(ch <-chan bool, done <-chan struct{}) <-chan bool {
+	if ch == nil || cap(ch) > 0 {
+		return ch
+	}
+	out := make(chan bool, 1)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-done:
+				return
+			case busy, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case <-out: // replaced by the latest value
+				default:
+				}
+				out <- busy
+			}
+		}
+	}()
+	return out
+}
+
+// partitionPauser is the subset of *kafka.Consumer used to apply back-pressure
+type partitionPauser interface {
+	Assignment() ([]kafka.TopicPartition, error)
+	Pause(partitions []kafka.TopicPartition) error
+	Resume(partitions []kafka.TopicPartition) error
+}
+
+// pressureBuffer queues messages between c.Events() and the StreamConsumer
+type pressureBuffer struct {
+	pauser partitionPauser
+	limit  int
+	queue  []*kafka.Message
+	busy   bool
+	paused bool
+}
+
+func newPressureBuffer(p partitionPauser, limit int) *pressureBuffer {
+	if limit < 1 {
+		limit = DefaultConsumeBuffer
+	}
+	return &pressureBuffer{pauser: p, limit: limit}
+}
+
+// push queues m, messages keep arriving for a short while after pausing since they
+// were already fetched, so the buffer may briefly exceed its limit.
+func (b *pressureBuffer) push(m *kafka.Message) error {
+	b.queue = append(b.queue, m)
+	if b.paused {
+		// the partition may have been assigned by a rebalance after pausing
+		return b.pauser.Pause([]kafka.TopicPartition{m.TopicPartition})
+	}
+	return nil
+}
+
+// pop returns the next message to deliver, if any and if the consumer isn't busy
+func (b *pressureBuffer) pop() (*kafka.Message, bool) {
+	if b.busy || len(b.queue) == 0 {
+		return nil, false
+	}
+	m := b.queue[0]
+	b.queue[0] = nil
+	b.queue = b.queue[1:]
+	return m, true
+}
+
+func (b *pressureBuffer) setBusy(busy bool) {
+	b.busy = busy
+}
+
+func (b *pressureBuffer) len() int {
+	return len(b.queue)
+}
+
+// apply pauses or resumes the assigned partitions to match the current state
+func (b *pressureBuffer) apply() error {
+	pause := b.busy || len(b.queue) >= b.limit
+	if pause == b.paused {
+		return nil
+	}
+
+	parts, err := b.pauser.Assignment()
+	if err != nil {
+		return err
+	}
+	if pause {
+		err = b.pauser.Pause(parts)
+	} else {
+		err = b.pauser.Resume(parts)
+	}
+	if err == nil {
+		b.paused = pause
+	}
+	return err
+}
+
+// toKafkaError passes a pause/resume error on to StreamConsumer.Error
+func toKafkaError(err error) kafka.Error {
+	if ke, ok := err.(kafka.Error); ok {
+		return ke
+	}
+	return kafka.NewError(kafka.ErrState, err.Error(), false)
+}
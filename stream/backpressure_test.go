@@ -0,0 +1,209 @@
+package stream
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePauser records Pause and Resume calls
+type fakePauser struct {
+	assigned []kafka.TopicPartition
+	paused   map[int32]bool
+	err      error
+}
+
+func newFakePauser(partitions ...int32) *fakePauser {
+	topic := "atsu.test"
+	p := &fakePauser{paused: make(map[int32]bool)}
+	for _, n := range partitions {
+		p.assigned = append(p.assigned, kafka.TopicPartition{Topic: &topic, Partition: n})
+	}
+	return p
+}
+
+func (p *fakePauser) Assignment() ([]kafka.TopicPartition, error) {
+	return p.assigned, p.err
+}
+
+func (p *fakePauser) Pause(partitions []kafka.TopicPartition) error {
+	for _, tp := range partitions {
+		p.paused[tp.Partition] = true
+	}
+	return p.err
+}
+
+func (p *fakePauser) Resume(partitions []kafka.TopicPartition) error {
+	for _, tp := range partitions {
+		delete(p.paused, tp.Partition)
+	}
+	return p.err
+}
+
+func testPartitionMessage(partition int32) *kafka.Message {
+	topic := "atsu.test"
+	return &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition}}
+}
+
+func TestPressureBufferLimit(t *testing.T) {
+	p := newFakePauser(0, 1)
+	b := newPressureBuffer(p, 2)
+	b.setBusy(true)
+
+	assert.NoError(t, b.push(testPartitionMessage(0)))
+	_, ok := b.pop()
+	assert.False(t, ok) // busy consumers get nothing
+
+	// busy pauses immediately
+	assert.NoError(t, b.apply())
+	assert.Equal(t, map[int32]bool{0: true, 1: true}, p.paused)
+
+	// a partition assigned by a rebalance while paused is paused too
+	p.assigned = append(p.assigned, testPartitionMessage(2).TopicPartition)
+	assert.NoError(t, b.push(testPartitionMessage(2)))
+	assert.True(t, p.paused[2])
+
+	// capacity reported, but the buffer is still full
+	b.setBusy(false)
+	assert.NoError(t, b.apply())
+	assert.Len(t, p.paused, 3)
+
+	m, ok := b.pop()
+	assert.True(t, ok)
+	assert.Equal(t, int32(0), m.TopicPartition.Partition)
+	assert.Equal(t, 1, b.len())
+	assert.NoError(t, b.apply())
+	assert.Empty(t, p.paused)
+}
+
+func TestPressureBufferErrors(t *testing.T) {
+	p := newFakePauser(0)
+	p.err = errors.New("no assignment")
+	b := newPressureBuffer(p, 0)
+	assert.Equal(t, DefaultConsumeBuffer, b.limit)
+
+	b.setBusy(true)
+	assert.Error(t, b.apply())
+	assert.False(t, b.paused) // retried on the next apply
+
+	ke := toKafkaError(p.err)
+	assert.Equal(t, kafka.ErrState, ke.Code())
+	assert.Equal(t, ke, toKafkaError(ke))
+}
+
+// busyConsumer reports busy after every `every` messages
+type busyConsumer struct {
+	StreamConsumer
+	every    int
+	max      int // Process stops once reached, if > 0
+	messages int
+	pressure chan bool
+}
+
+func (c *busyConsumer) Message(*kafka.Message) error {
+	c.messages++
+	if c.messages%c.every == 0 {
+		c.pressure <- true
+	}
+	return nil
+}
+
+func (c *busyConsumer) PressureCh() <-chan bool {
+	return c.pressure
+}
+
+func (c *busyConsumer) Process() (bool, error) {
+	return c.max > 0 && c.messages >= c.max, nil
+}
+
+func TestDeliverBackPressure(t *testing.T) {
+	sc := &StreamConfig{}
+	c := &busyConsumer{every: 2, pressure: make(chan bool, 1)}
+	p := newFakePauser(0)
+	b := newPressureBuffer(p, 10)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, b.push(testPartitionMessage(0)))
+	}
+
+	pressureCh := c.PressureCh()
+	delivered, stop := sc.deliver(c, b, &pressureCh)
+	assert.False(t, stop)
+	assert.Equal(t, 2, delivered)
+	assert.Equal(t, 2, c.messages)
+	assert.Equal(t, 3, b.len())
+	assert.NoError(t, b.apply())
+	assert.True(t, p.paused[0])
+
+	// capacity again
+	c.pressure <- false
+	_, stop = sc.deliver(c, b, &pressureCh)
+	assert.False(t, stop)
+	assert.Equal(t, 4, c.messages)
+	assert.Equal(t, 1, b.len())
+
+	c.pressure <- false
+	_, stop = sc.deliver(c, b, &pressureCh)
+	assert.False(t, stop)
+	assert.Equal(t, 5, c.messages)
+	assert.NoError(t, b.apply())
+	assert.Empty(t, p.paused)
+}
+
+func TestDeliverProcessStops(t *testing.T) {
+	sc := &StreamConfig{}
+	c := &busyConsumer{every: 100, max: 3, pressure: make(chan bool, 1)}
+	b := newPressureBuffer(newFakePauser(0), 10)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, b.push(testPartitionMessage(0)))
+	}
+	pressureCh := c.PressureCh()
+	delivered, stop := sc.deliver(c, b, &pressureCh)
+	assert.True(t, stop)
+	assert.Equal(t, 3, delivered)
+	assert.Equal(t, 3, c.messages)
+}
+
+func TestDeliverClosedPressureCh(t *testing.T) {
+	sc := &StreamConfig{}
+	c := &busyConsumer{every: 100, pressure: make(chan bool, 1)}
+	b := newPressureBuffer(newFakePauser(0), 10)
+	assert.NoError(t, b.push(testPartitionMessage(0)))
+	close(c.pressure)
+	pressureCh := c.PressureCh()
+	delivered, stop := sc.deliver(c, b, &pressureCh)
+	assert.False(t, stop)
+	assert.Equal(t, 1, delivered)
+	assert.Nil(t, pressureCh)
+}
+
+func TestDeliverUnbufferedPressureCh(t *testing.T) {
+	sc := &StreamConfig{}
+	c := &busyConsumer{every: 2, pressure: make(chan bool)}
+	b := newPressureBuffer(newFakePauser(0), 10)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, b.push(testPartitionMessage(0)))
+	}
+	done := make(chan struct{})
+	defer close(done)
+
+	// sending from Message doesn't deadlock, and busy takes effect shortly after
+	pressureCh := relayPressure(c.PressureCh(), done)
+	delivered, stop := sc.deliver(c, b, &pressureCh)
+	assert.False(t, stop)
+	assert.True(t, delivered >= 2 && delivered <= 4, "delivered %d", delivered)
+	assert.Eventually(t, func() bool {
+		sc.deliver(c, b, &pressureCh)
+		return b.busy
+	}, time.Second, time.Millisecond)
+
+	c.every = 100
+	close(c.pressure)
+	assert.Eventually(t, func() bool {
+		sc.deliver(c, b, &pressureCh)
+		return pressureCh == nil
+	}, time.Second, time.Millisecond)
+	assert.False(t, b.busy)
+}
@@ -1,6 +1,7 @@
 package stream
 
 import (
+	"context"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
@@ -18,6 +19,13 @@ type StreamConsumer interface {
 	DoneCh() <-chan bool
 }
 
+// TracedStreamConsumer may be implemented by a StreamConsumer to receive each message along with
+// a ctx carrying the "consume" span, which is a child of the span extracted from the message headers.
+// When implemented, Consume calls MessageContext instead of Message.
+type TracedStreamConsumer interface {
+	MessageContext(context.Context, *kafka.Message) error // error != nil, stop consumer
+}
+
 func (sc *StreamConfig) Consume(consumer StreamConsumer, config interface{}) error {
 	// Connect to Kafka
 	c, err := sc.NewConsumer(nil)
@@ -47,6 +55,15 @@ func (sc *StreamConfig) Consume(consumer StreamConsumer, config interface{}) err
 		return err
 	}
 
+	// a nil pressureCh never delivers, so consumers without back-pressure are never paused
+	var pressureCh <-chan bool
+	if bp, ok := consumer.(BackPressureConsumer); ok {
+		done := make(chan struct{})
+		defer close(done)
+		pressureCh = relayPressure(bp.PressureCh(), done)
+	}
+	buf := newPressureBuffer(c, sc.Buffer)
+
 	run := true
 	for run {
 		select {
@@ -56,7 +73,7 @@ func (sc *StreamConfig) Consume(consumer StreamConsumer, config interface{}) err
 				sc.Messages += 1
 				sc.Bytes += len(e.Value)
 
-				if err := consumer.Message(e); err != nil {
+				if err := buf.push(e); err != nil && consumer.Error(toKafkaError(err)) {
 					run = false
 				}
 			case kafka.Error:
@@ -80,14 +97,84 @@ func (sc *StreamConfig) Consume(consumer StreamConsumer, config interface{}) err
 			if consumer.Timeout(t, stalled) {
 				run = false
 			}
+		case busy, ok := <-pressureCh:
+			if !ok { // closed, the consumer no longer applies back-pressure
+				pressureCh = nil
+				busy = false
+			}
+			buf.setBusy(busy)
 		case <-consumer.DoneCh():
 			run = false
 		}
 
-		if stop, err := consumer.Process(); err != nil || stop {
+		delivered := 0
+		if run {
+			var stop bool
+			if delivered, stop = sc.deliver(consumer, buf, &pressureCh); stop {
+				run = false
+			}
+		}
+		if err := buf.apply(); err != nil && consumer.Error(toKafkaError(err)) {
 			run = false
 		}
 
+		// deliver calls Process after each message
+		if delivered == 0 {
+			if stop, err := consumer.Process(); err != nil || stop {
+				run = false
+			}
+		}
+
 	}
 	return consumer.Finish()
 }
+
+// deliver hands buffered messages to the consumer until it is empty or the consumer reports busy.
+// Like every unbuffered message, each is followed by a call to Process, so that its stop conditions
+// (e.g. a maximum count) are checked per message. It returns the number of messages delivered, and true
+// once Message returns an error or Process asks to stop. A closed pressureCh is set to nil.
+func (sc *StreamConfig) deliver(consumer StreamConsumer, buf *pressureBuffer, pressureCh *<-chan bool) (int, bool) {
+	delivered := 0
+	for {
+		select {
+		case busy, ok := <-*pressureCh:
+			if !ok {
+				*pressureCh = nil
+				busy = false
+			}
+			buf.setBusy(busy)
+		default:
+		}
+
+		m, ok := buf.pop()
+		if !ok {
+			return delivered, false
+		}
+		delivered++
+		if err := sc.consumeMessage(consumer, m); err != nil {
+			return delivered, true
+		}
+		if stop, err := consumer.Process(); err != nil || stop {
+			return delivered, true
+		}
+	}
+}
+
+// consumeMessage hands m to the consumer within a "consume" span
+func (sc *StreamConfig) consumeMessage(consumer StreamConsumer, m *kafka.Message) error {
+	ctx := context.Background()
+	if parent, ok := ExtractTrace(m); ok {
+		ctx = ContextWithSpan(ctx, parent)
+	}
+	ctx, span := sc.Tracer().Start(ctx, "consume")
+	setMessageAttributes(span, m)
+
+	var err error
+	if tc, ok := consumer.(TracedStreamConsumer); ok {
+		err = tc.MessageContext(ctx, m)
+	} else {
+		err = consumer.Message(m)
+	}
+	span.End(err)
+	return err
+}
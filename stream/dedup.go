@@ -0,0 +1,152 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// IdExtractor returns the id used to detect duplicates of m, or false if m has none.
+// Messages without an id are never treated as duplicates.
+type IdExtractor func(m *kafka.Message) (string, bool)
+
+// KeyId uses the message key as the id
+func KeyId() IdExtractor {
+	return func(m *kafka.Message) (string, bool) {
+		return string(m.Key), len(m.Key) > 0
+	}
+}
+
+// HeaderId uses the value of the named header as the id
+func HeaderId(name string) IdExtractor {
+	return func(m *kafka.Message) (string, bool) {
+		for _, h := range m.Headers {
+			if h.Key == name && len(h.Value) > 0 {
+				return string(h.Value), true
+			}
+		}
+		return "", false
+	}
+}
+
+// PayloadId uses a field of the json payload as the id, nested fields are separated by '.'
+// e.g. PayloadId("payload.id") for an Envelope whose payload has an id field.
+func PayloadId(field string) IdExtractor {
+	path := strings.Split(field, ".")
+	return func(m *kafka.Message) (string, bool) {
+		d := json.NewDecoder(bytes.NewReader(m.Value))
+		d.UseNumber() // keep large numeric ids intact
+
+		var v interface{}
+		if err := d.Decode(&v); err != nil {
+			return "", false
+		}
+		for _, p := range path {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return "", false
+			}
+			if v, ok = obj[p]; !ok {
+				return "", false
+			}
+		}
+		switch id := v.(type) {
+		case string:
+			return id, id != ""
+		case json.Number:
+			return id.String(), true
+		case nil, map[string]interface{}, []interface{}:
+			return "", false
+		default:
+			return fmt.Sprint(id), true
+		}
+	}
+}
+
+// DedupStore remembers message ids, see MemoryDedupStore and FileDedupStore
+type DedupStore interface {
+	Contains(id string) (bool, error)
+	Add(id string) error
+	Close() error
+}
+
+// DedupStats are the counts kept by a DedupConsumer
+type DedupStats struct {
+	Hits   uint64 `json:"hits"`   // duplicates skipped
+	Misses uint64 `json:"misses"` // messages passed through
+	NoId   uint64 `json:"no_id"`  // messages passed through without an id
+	Errors uint64 `json:"errors"` // store errors, these messages are passed through
+}
+
+// DedupConsumer wraps a StreamConsumer and skips messages whose id has already been consumed.
+// An id is only stored once the wrapped consumer handles the message without error, so a
+// message that stops the consumer is not lost on restart.
+type DedupConsumer struct {
+	StreamConsumer
+
+	extract IdExtractor
+	store   DedupStore
+	stats   DedupStats
+}
+
+var _ StreamConsumer = &DedupConsumer{}
+var _ TracedStreamConsumer = &DedupConsumer{}
+
+func NewDedupConsumer(next StreamConsumer, extract IdExtractor, store DedupStore) *DedupConsumer {
+	return &DedupConsumer{
+		StreamConsumer: next,
+		extract:        extract,
+		store:          store}
+}
+
+func (d *DedupConsumer) Message(m *kafka.Message) error {
+	return d.MessageContext(context.Background(), m)
+}
+
+func (d *DedupConsumer) MessageContext(ctx context.Context, m *kafka.Message) error {
+	id, ok := d.extract(m)
+	if !ok {
+		atomic.AddUint64(&d.stats.NoId, 1)
+		return d.next(ctx, m)
+	}
+
+	seen, err := d.store.Contains(id)
+	if err != nil {
+		atomic.AddUint64(&d.stats.Errors, 1)
+	}
+	if seen {
+		atomic.AddUint64(&d.stats.Hits, 1)
+		return nil
+	}
+	atomic.AddUint64(&d.stats.Misses, 1)
+
+	if err := d.next(ctx, m); err != nil {
+		return err
+	}
+	if err := d.store.Add(id); err != nil {
+		atomic.AddUint64(&d.stats.Errors, 1)
+	}
+	return nil
+}
+
+func (d *DedupConsumer) next(ctx context.Context, m *kafka.Message) error {
+	if tc, ok := d.StreamConsumer.(TracedStreamConsumer); ok {
+		return tc.MessageContext(ctx, m)
+	}
+	return d.StreamConsumer.Message(m)
+}
+
+// Stats returns a snapshot of the hit/miss counts
+func (d *DedupConsumer) Stats() DedupStats {
+	return DedupStats{
+		Hits:   atomic.LoadUint64(&d.stats.Hits),
+		Misses: atomic.LoadUint64(&d.stats.Misses),
+		NoId:   atomic.LoadUint64(&d.stats.NoId),
+		Errors: atomic.LoadUint64(&d.stats.Errors),
+	}
+}
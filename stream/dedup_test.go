@@ -0,0 +1,211 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdExtractors(t *testing.T) {
+	m := &kafka.Message{
+		Key:     []byte("k1"),
+		Headers: []kafka.Header{{Key: "id", Value: []byte("h1")}, {Key: "empty"}},
+		Value:   []byte(`{"id":"p1","n":12345678901234567890,"payload":{"id":7},"obj":{},"null":null}`),
+	}
+	tests := []struct {
+		name    string
+		extract IdExtractor
+		id      string
+		ok      bool
+	}{
+		{"key", KeyId(), "k1", true},
+		{"header", HeaderId("id"), "h1", true},
+		{"empty header", HeaderId("empty"), "", false},
+		{"missing header", HeaderId("nope"), "", false},
+		{"payload", PayloadId("id"), "p1", true},
+		{"payload number", PayloadId("n"), "12345678901234567890", true},
+		{"payload nested", PayloadId("payload.id"), "7", true},
+		{"payload object", PayloadId("obj"), "", false},
+		{"payload null", PayloadId("null"), "", false},
+		{"payload missing", PayloadId("payload.nope"), "", false},
+		{"payload not an object", PayloadId("id.deeper"), "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, ok := test.extract(m)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.id, id)
+		})
+	}
+
+	_, ok := KeyId()(&kafka.Message{})
+	assert.False(t, ok)
+	_, ok = PayloadId("id")(&kafka.Message{Value: []byte("not json")})
+	assert.False(t, ok)
+}
+
+// countingConsumer counts messages and fails any message whose value is "fail"
+type countingConsumer struct {
+	StreamConsumer
+	messages int
+}
+
+func (c *countingConsumer) Message(m *kafka.Message) error {
+	if string(m.Value) == "fail" {
+		return errors.New("fail")
+	}
+	c.messages++
+	return nil
+}
+
+func TestDedupConsumer(t *testing.T) {
+	next := &countingConsumer{}
+	d := NewDedupConsumer(next, KeyId(), NewMemoryDedupStore(10))
+
+	msg := func(key, value string) *kafka.Message {
+		return &kafka.Message{Key: []byte(key), Value: []byte(value)}
+	}
+
+	assert.NoError(t, d.Message(msg("a", "")))
+	assert.NoError(t, d.Message(msg("a", ""))) // duplicate
+	assert.NoError(t, d.Message(msg("b", "")))
+	assert.NoError(t, d.Message(msg("", ""))) // no id
+
+	// a failed message is not remembered, so it is retried
+	assert.Error(t, d.Message(msg("c", "fail")))
+	assert.NoError(t, d.Message(msg("c", "")))
+
+	assert.Equal(t, 4, next.messages)
+	assert.Equal(t, DedupStats{Hits: 1, Misses: 4, NoId: 1}, d.Stats())
+}
+
+func TestMemoryDedupStore(t *testing.T) {
+	s := NewMemoryDedupStore(2)
+	assert.NoError(t, s.Add("a"))
+	assert.NoError(t, s.Add("b"))
+
+	// touch a, so b is the least recently used
+	ok, _ := s.Contains("a")
+	assert.True(t, ok)
+
+	assert.NoError(t, s.Add("c"))
+	assert.Equal(t, 2, s.Len())
+	ok, _ = s.Contains("b")
+	assert.False(t, ok)
+	ok, _ = s.Contains("a")
+	assert.True(t, ok)
+	ok, _ = s.Contains("c")
+	assert.True(t, ok)
+	assert.NoError(t, s.Close())
+}
+
+func TestFileDedupStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ids")
+
+	now := time.Unix(1559761560, 0)
+	clock := func() time.Time { return now }
+
+	s, err := newFileDedupStore(path, time.Minute, 0, clock)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Add("a"))
+	now = now.Add(30 * time.Second)
+	assert.NoError(t, s.Add("b c\nd")) // ids may hold any character
+	assert.NoError(t, s.Close())
+	assert.Error(t, s.Add("closed"))
+
+	// reopened, both ids are still there
+	s, err = newFileDedupStore(path, time.Minute, 0, clock)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, s.Len())
+	ok, _ := s.Contains("b c\nd")
+	assert.True(t, ok)
+
+	// a expires first
+	now = now.Add(31 * time.Second)
+	ok, _ = s.Contains("a")
+	assert.False(t, ok)
+	ok, _ = s.Contains("b c\nd")
+	assert.True(t, ok)
+	assert.NoError(t, s.Close())
+
+	// expired ids are dropped when reopening
+	now = now.Add(time.Minute)
+	s, err = newFileDedupStore(path, time.Minute, 0, clock)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, s.Len())
+	assert.NoError(t, s.Close())
+
+	// ids loaded with a longer ttl don't keep shorter lived ones from expiring
+	s, err = newFileDedupStore(path, time.Hour, 0, clock)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Add("long"))
+	assert.NoError(t, s.Close())
+	s, err = newFileDedupStore(path, time.Minute, 0, clock)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Add("short"))
+	now = now.Add(2 * time.Minute)
+	ok, _ = s.Contains("short")
+	assert.False(t, ok)
+	ok, _ = s.Contains("long")
+	assert.True(t, ok)
+	assert.NoError(t, s.Close())
+
+	_, err = NewFileDedupStore(path, 0, 0)
+	assert.Error(t, err)
+}
+
+func TestFileDedupStoreMaxAndCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ids")
+
+	s, err := NewFileDedupStore(path, time.Hour, 10)
+	assert.NoError(t, err)
+	for i := 0; i < minCompactLines*2; i++ {
+		assert.NoError(t, s.Add(string(rune('a'+i%26))+time.Duration(i).String()))
+	}
+	assert.Equal(t, 10, s.Len())
+	assert.True(t, s.lines <= minCompactLines+1, s.lines)
+	assert.NoError(t, s.Close())
+
+	s, err = NewFileDedupStore(path, time.Hour, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, s.Len())
+	assert.Equal(t, 10, s.lines)
+
+	// a failed compaction keeps appending to the current file, and is retried on the next Add
+	assert.NoError(t, os.Mkdir(path+".tmp", 0755))
+	var id string
+	for i := 0; err == nil; i++ {
+		id = fmt.Sprintf("f%d", i)
+		err = s.Add(id)
+	}
+	b, _ := ioutil.ReadFile(path)
+	assert.Contains(t, string(b), strconv.Quote(id))
+	assert.NoError(t, os.Remove(path+".tmp"))
+	assert.NoError(t, s.Add("last"))
+	assert.Equal(t, 10, s.lines)
+	assert.NoError(t, s.Close())
+
+	s, err = NewFileDedupStore(path, time.Hour, 10)
+	assert.NoError(t, err)
+	ok, _ := s.Contains("last")
+	assert.True(t, ok)
+	assert.NoError(t, s.Close())
+}
@@ -0,0 +1,267 @@
+package stream
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryDedupStore is a DedupStore keeping the most recently used ids in memory
+type MemoryDedupStore struct {
+	mux   sync.Mutex
+	size  int
+	ids   map[string]*list.Element
+	order *list.List // front is least recently used
+}
+
+var _ DedupStore = &MemoryDedupStore{}
+
+// NewMemoryDedupStore creates an LRU store holding at most size ids
+func NewMemoryDedupStore(size int) *MemoryDedupStore {
+	if size < 1 {
+		size = 1
+	}
+	return &MemoryDedupStore{
+		size:  size,
+		ids:   make(map[string]*list.Element),
+		order: list.New()}
+}
+
+func (s *MemoryDedupStore) Contains(id string) (bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if e, ok := s.ids[id]; ok {
+		s.order.MoveToBack(e)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *MemoryDedupStore) Add(id string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if e, ok := s.ids[id]; ok {
+		s.order.MoveToBack(e)
+		return nil
+	}
+	s.ids[id] = s.order.PushBack(id)
+	for s.order.Len() > s.size {
+		delete(s.ids, s.order.Remove(s.order.Front()).(string))
+	}
+	return nil
+}
+
+// Len returns the number of ids held
+func (s *MemoryDedupStore) Len() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.order.Len()
+}
+
+func (s *MemoryDedupStore) Close() error {
+	return nil
+}
+
+// minCompactLines is the fewest log lines before a FileDedupStore considers compacting
+const minCompactLines = 1024
+
+// FileDedupStore is a DedupStore that survives restarts. Ids expire after a TTL, and are
+// kept in memory backed by an append-only log file which is compacted as it grows.
+type FileDedupStore struct {
+	mux   sync.Mutex
+	path  string
+	ttl   time.Duration
+	max   int
+	ids   map[string]*list.Element
+	order *list.List // of *fileDedupEntry, ordered by expiry
+	file  *os.File
+	lines int // lines in file, live or not
+
+	now func() time.Time
+}
+
+type fileDedupEntry struct {
+	id      string
+	expires time.Time
+}
+
+var _ DedupStore = &FileDedupStore{}
+
+// NewFileDedupStore opens (or creates) the store at path, ids expire after ttl and
+// at most max ids are held (max < 1 is unbounded)
+func NewFileDedupStore(path string, ttl time.Duration, max int) (*FileDedupStore, error) {
+	return newFileDedupStore(path, ttl, max, time.Now)
+}
+
+func newFileDedupStore(path string, ttl time.Duration, max int, now func() time.Time) (*FileDedupStore, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("dedup store ttl must be positive, got %s", ttl)
+	}
+	s := &FileDedupStore{
+		path:  path,
+		ttl:   ttl,
+		max:   max,
+		ids:   make(map[string]*list.Element),
+		order: list.New(),
+		now:   now}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load replays the log file, each line is `<expiry unix nanos> <quoted id>`
+func (s *FileDedupStore) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	now := s.now()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " ", 2)
+		if len(parts) != 2 {
+			continue // a torn write, skip it
+		}
+		nanos, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		id, err := strconv.Unquote(parts[1])
+		if err != nil {
+			continue
+		}
+		if expires := time.Unix(0, nanos); expires.After(now) {
+			s.put(id, expires)
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *FileDedupStore) Contains(id string) (bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	now := s.now()
+	s.expire(now)
+	e, ok := s.ids[id]
+	return ok && e.Value.(*fileDedupEntry).expires.After(now), nil
+}
+
+func (s *FileDedupStore) Add(id string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.file == nil {
+		return fmt.Errorf("dedup store %s is closed", s.path)
+	}
+
+	now := s.now()
+	s.expire(now)
+	expires := now.Add(s.ttl)
+	s.put(id, expires)
+
+	if _, err := fmt.Fprintf(s.file, "%d %s\n", expires.UnixNano(), strconv.Quote(id)); err != nil {
+		return err
+	}
+	s.lines++
+
+	if s.lines > minCompactLines && s.lines > 2*s.order.Len() {
+		return s.compact()
+	}
+	return nil
+}
+
+// put adds or refreshes id, evicting the ids expiring first beyond max.
+// Entries usually arrive in expiry order, but not when loaded after a ttl change or when the clock steps back,
+// so id is inserted after the last entry expiring no later than it.
+func (s *FileDedupStore) put(id string, expires time.Time) {
+	if e, ok := s.ids[id]; ok {
+		s.order.Remove(e)
+	}
+	entry := &fileDedupEntry{id: id, expires: expires}
+	mark := s.order.Back()
+	for mark != nil && mark.Value.(*fileDedupEntry).expires.After(expires) {
+		mark = mark.Prev()
+	}
+	if mark == nil {
+		s.ids[id] = s.order.PushFront(entry)
+	} else {
+		s.ids[id] = s.order.InsertAfter(entry, mark)
+	}
+	for s.max > 0 && s.order.Len() > s.max {
+		delete(s.ids, s.order.Remove(s.order.Front()).(*fileDedupEntry).id)
+	}
+}
+
+// expire drops ids whose ttl has passed, the front expires first as put keeps the order by expiry
+func (s *FileDedupStore) expire(now time.Time) {
+	for e := s.order.Front(); e != nil; e = s.order.Front() {
+		entry := e.Value.(*fileDedupEntry)
+		if entry.expires.After(now) {
+			return
+		}
+		s.order.Remove(e)
+		delete(s.ids, entry.id)
+	}
+}
+
+// compact rewrites the log file with only the live ids and appends to the rewritten file from then on.
+// If the rewrite fails the current file is kept, and compaction is retried on a later Add.
+func (s *FileDedupStore) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for e := s.order.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*fileDedupEntry)
+		fmt.Fprintf(w, "%d %s\n", entry.expires.UnixNano(), strconv.Quote(entry.id))
+	}
+	err = w.Flush()
+	if err == nil {
+		err = os.Rename(tmp, s.path) // f now appends to path
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if s.file != nil {
+		s.file.Close() // already replaced
+	}
+	s.file = f
+	s.lines = s.order.Len()
+	return nil
+}
+
+// Len returns the number of ids held, expired ids may be included until the next Contains or Add
+func (s *FileDedupStore) Len() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.order.Len()
+}
+
+func (s *FileDedupStore) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
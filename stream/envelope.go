@@ -0,0 +1,143 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/atsu/goat/build"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// EnvelopeVersion is the current (and highest supported) Envelope version
+const EnvelopeVersion = 1
+
+// ErrInvalidEnvelope is returned (wrapped) whenever an Envelope fails validation
+var ErrInvalidEnvelope = errors.New("invalid envelope")
+
+// Envelope is the canonical wrapper for all stream payloads
+// Example of a json encoded envelope
+//
+//	{"v":1, "source":"gather", "host":"host123", "build":"v0.0.1", "etype":"health",
+//	 "timestamp":1559761560123, "trace_id":"4bf92f3577b34da6a3ce929d0e0e4736",
+//	 "payload": { "state": "green", "msg": "a-ok" }}
+type Envelope struct {
+	Version   int             `json:"v"`                  // Envelope version, see EnvelopeVersion
+	Source    string          `json:"source"`             // Emitting service (e.g. gather)
+	Host      string          `json:"host"`               // Emitting host
+	Build     string          `json:"build"`              // Emitting service build version
+	Type      string          `json:"etype"`              // Type of payload (e.g. health, log)
+	Timestamp int64           `json:"timestamp"`          // Unix milliseconds
+	TraceId   string          `json:"trace_id,omitempty"` // Optional trace the payload belongs to, see ProduceEnvelopeContext
+	Payload   json.RawMessage `json:"payload"`            // Type specific payload
+}
+
+// NewEnvelope marshals payload and wraps it in an Envelope stamped with the host, build and current time
+func NewEnvelope(source, etype string, payload interface{}) (*Envelope, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	hn, _ := os.Hostname()
+
+	return &Envelope{
+		Version:   EnvelopeVersion,
+		Source:    source,
+		Host:      hn,
+		Build:     build.GetInfo(source).Version,
+		Type:      etype,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Payload:   b,
+	}, nil
+}
+
+// Validate returns a wrapped ErrInvalidEnvelope describing the first problem found, or nil
+func (e Envelope) Validate() error {
+	switch {
+	case e.Version < 1 || e.Version > EnvelopeVersion:
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidEnvelope, e.Version)
+	case e.Source == "":
+		return fmt.Errorf("%w: missing source", ErrInvalidEnvelope)
+	case e.Host == "":
+		return fmt.Errorf("%w: missing host", ErrInvalidEnvelope)
+	case e.Type == "":
+		return fmt.Errorf("%w: missing etype", ErrInvalidEnvelope)
+	case e.Timestamp <= 0:
+		return fmt.Errorf("%w: missing timestamp", ErrInvalidEnvelope)
+	case len(e.Payload) == 0:
+		return fmt.Errorf("%w: missing payload", ErrInvalidEnvelope)
+	case !json.Valid(e.Payload):
+		return fmt.Errorf("%w: payload is not valid json", ErrInvalidEnvelope)
+	}
+	return nil
+}
+
+// Time returns the envelope Timestamp as a time.Time
+func (e Envelope) Time() time.Time {
+	return time.Unix(0, e.Timestamp*int64(time.Millisecond))
+}
+
+// Decode unmarshals the payload into v
+func (e Envelope) Decode(v interface{}) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+// WrapEnvelope wraps payload in a new Envelope and returns it json encoded
+func WrapEnvelope(source, etype string, payload interface{}) ([]byte, error) {
+	e, err := NewEnvelope(source, etype, payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(e)
+}
+
+// UnwrapEnvelope decodes and validates a json encoded Envelope, and if payload is non-nil
+// the Envelope payload is unmarshalled into it.
+func UnwrapEnvelope(b []byte, payload interface{}) (*Envelope, error) {
+	var e Envelope
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidEnvelope, err.Error())
+	}
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		if err := e.Decode(payload); err != nil {
+			return &e, err
+		}
+	}
+	return &e, nil
+}
+
+// ProduceEnvelope validates and produces e to topic, continuing the trace of e.TraceId if set,
+// see ProduceEnvelopeContext
+func (sc *StreamConfig) ProduceEnvelope(topic *string, e *Envelope) error {
+	return sc.ProduceEnvelopeContext(context.Background(), topic, e)
+}
+
+// ProduceEnvelopeContext validates and produces e to topic like ProduceContext. The trace is the one of
+// the span in ctx, or else the one of e.TraceId, or else a new one, and e.TraceId is set to it.
+func (sc *StreamConfig) ProduceEnvelopeContext(ctx context.Context, topic *string, e *Envelope) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	if _, ok := SpanFromContext(ctx); !ok && e.TraceId != "" {
+		// the span that set e.TraceId is unknown, continue its trace from a stand-in
+		parent := NewSpanContext(SpanContext{})
+		if decodeHex(parent.TraceId[:], e.TraceId) == nil && parent.IsValid() {
+			ctx = ContextWithSpan(ctx, parent)
+		}
+	}
+	return sc.produceContext(ctx, topic, func(span SpanContext) ([]byte, error) {
+		e.TraceId = span.TraceIdString()
+		return json.Marshal(e)
+	})
+}
+
+// ConsumeEnvelope is the consuming counterpart of ProduceEnvelope, see UnwrapEnvelope
+func ConsumeEnvelope(m *kafka.Message, payload interface{}) (*Envelope, error) {
+	return UnwrapEnvelope(m.Value, payload)
+}
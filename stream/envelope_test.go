@@ -0,0 +1,111 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+type testPayload struct {
+	State string `json:"state"`
+	Count int    `json:"count"`
+}
+
+func TestNewEnvelope(t *testing.T) {
+	e, err := NewEnvelope("gather", "health", testPayload{"green", 3})
+	assert.NoError(t, err)
+	assert.NoError(t, e.Validate())
+
+	hn, _ := os.Hostname()
+	assert.Equal(t, EnvelopeVersion, e.Version)
+	assert.Equal(t, "gather", e.Source)
+	assert.Equal(t, hn, e.Host)
+	assert.NotEmpty(t, e.Build)
+	assert.Equal(t, "health", e.Type)
+	assert.NotZero(t, e.Timestamp)
+	assert.JSONEq(t, `{"state":"green","count":3}`, string(e.Payload))
+
+	_, err = NewEnvelope("gather", "health", make(chan int))
+	assert.Error(t, err)
+}
+
+func TestWrapUnwrapEnvelope(t *testing.T) {
+	b, err := WrapEnvelope("gather", "health", testPayload{"yellow", 7})
+	assert.NoError(t, err)
+
+	var got testPayload
+	e, err := UnwrapEnvelope(b, &got)
+	assert.NoError(t, err)
+	assert.Equal(t, "gather", e.Source)
+	assert.Equal(t, testPayload{"yellow", 7}, got)
+
+	// consuming a message is the same as unwrapping its value
+	got = testPayload{}
+	e, err = ConsumeEnvelope(&kafka.Message{Value: b}, &got)
+	assert.NoError(t, err)
+	assert.Equal(t, "health", e.Type)
+	assert.Equal(t, testPayload{"yellow", 7}, got)
+
+	// a nil payload only unwraps the envelope
+	e, err = UnwrapEnvelope(b, nil)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, e.Payload)
+}
+
+func TestEnvelopeValidate(t *testing.T) {
+	valid := func() Envelope {
+		return Envelope{Version: 1, Source: "s", Host: "h", Type: "t", Timestamp: 1, Payload: json.RawMessage(`{}`)}
+	}
+	tests := []struct {
+		name   string
+		modify func(*Envelope)
+		valid  bool
+	}{
+		{"valid", func(e *Envelope) {}, true},
+		{"no build or trace is valid", func(e *Envelope) { e.Build = ""; e.TraceId = "" }, true},
+		{"version zero", func(e *Envelope) { e.Version = 0 }, false},
+		{"future version", func(e *Envelope) { e.Version = EnvelopeVersion + 1 }, false},
+		{"no source", func(e *Envelope) { e.Source = "" }, false},
+		{"no host", func(e *Envelope) { e.Host = "" }, false},
+		{"no type", func(e *Envelope) { e.Type = "" }, false},
+		{"no timestamp", func(e *Envelope) { e.Timestamp = 0 }, false},
+		{"no payload", func(e *Envelope) { e.Payload = nil }, false},
+		{"bad payload", func(e *Envelope) { e.Payload = json.RawMessage(`{"`) }, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := valid()
+			test.modify(&e)
+			err := e.Validate()
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, ErrInvalidEnvelope), err)
+			}
+		})
+	}
+}
+
+func TestUnwrapEnvelopeMalformed(t *testing.T) {
+	for _, in := range []string{``, `not json`, `{}`, `{"v":1,"source":"s","host":"h","etype":"t","timestamp":1}`} {
+		_, err := UnwrapEnvelope([]byte(in), nil)
+		assert.True(t, errors.Is(err, ErrInvalidEnvelope), in)
+	}
+
+	// a valid envelope with a payload that doesn't fit the target
+	b, _ := WrapEnvelope("gather", "health", "a string")
+	var got testPayload
+	_, err := UnwrapEnvelope(b, &got)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrInvalidEnvelope))
+}
+
+func TestEnvelopeTime(t *testing.T) {
+	e := Envelope{Timestamp: 1559761560123}
+	assert.Equal(t, int64(1559761560), e.Time().Unix())
+	assert.Equal(t, 123, e.Time().Nanosecond()/1e6)
+}
@@ -0,0 +1,25 @@
+package mocks
+
+import (
+	kafka "github.com/confluentinc/confluent-kafka-go/kafka"
+	mock "github.com/stretchr/testify/mock"
+)
+
+// NewProducingStreamConfig returns a KafkaStreamConfig mock with a producer, whose topics are `<prefix>.<topic>`.
+// Each produced message is passed to produce (if set), whose error is returned by Produce.
+// Flush is not mocked, what it returns is up to the test.
+func NewProducingStreamConfig(prefix string, produce func(topic string, value []byte) error) *KafkaStreamConfig {
+	p := &kafka.Producer{}
+	m := new(KafkaStreamConfig)
+	m.On("FullTopic", mock.AnythingOfType("string")).Return(func(t string) string { return prefix + "." + t })
+	m.On("GetProducer").Return(p)
+	m.On("NewProducer", mock.Anything).Return(p, nil)
+	m.On("Produce", mock.AnythingOfType("*string"), mock.AnythingOfType("[]uint8")).Return(func(topic *string, value []byte) error {
+		if produce == nil {
+			return nil
+		}
+		return produce(*topic, value)
+	})
+	m.On("Close").Return(nil)
+	return m
+}
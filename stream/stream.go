@@ -1,6 +1,7 @@
 package stream
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -35,9 +36,12 @@ type StreamConfig struct {
 
 	Codec string `default:"none" json:"codec" yaml:"codec"`
 
+	Buffer int `json:"buffer,omitempty" yaml:"buffer,omitempty"` // Consume buffer size, defaults to DefaultConsumeBuffer
+
 	producer      *kafka.Producer
 	consumer      *kafka.Consumer
 	deliveryError func(*kafka.Message)
+	tracer        Tracer
 }
 
 // String returns JSON representation
@@ -56,6 +60,7 @@ func (sc *StreamConfig) SetFlags() {
 	flag.StringVar(&sc.GroupId, "groupid", sc.GroupId, "Group ID")
 	flag.StringVar(&sc.Codec, "codec", sc.Codec, "Compression")
 	flag.BoolVar(&sc.Glob, "glob", sc.Glob, "Add glob .* to topic")
+	flag.IntVar(&sc.Buffer, "buffer", sc.Buffer, "Consume buffer size")
 
 	envconfig.Process(config.AtsuConfigEnvPrefix, sc)
 }
@@ -64,6 +69,20 @@ func (sc *StreamConfig) SetDeliveryError(f func(*kafka.Message)) {
 	sc.deliveryError = f
 }
 
+// SetTracer sets the Tracer used to record produce, deliver and consume spans,
+// it must be called before NewProducer to trace deliveries. Defaults to NoopTracer.
+func (sc *StreamConfig) SetTracer(t Tracer) {
+	sc.tracer = t
+}
+
+// Tracer returns the configured Tracer
+func (sc StreamConfig) Tracer() Tracer {
+	if sc.tracer == nil {
+		return NoopTracer{}
+	}
+	return sc.tracer
+}
+
 const SessionTimeoutDefault = 6000 // ms
 
 // consumerDefaults returns a *kafka.ConfigMap with sane defaults
@@ -86,11 +105,7 @@ func (sc *StreamConfig) NewConsumer(km *kafka.ConfigMap) (*kafka.Consumer, error
 		km = sc.consumerDefaults()
 	}
 	if c, err := kafka.NewConsumer(km); err == nil {
-		topic := sc.FullTopic("")
-		if sc.Glob {
-			topic := fmt.Sprintf("^%s", sc.FullTopic(""))
-			topic = topic + ".*"
-		}
+		topic := sc.subscription()
 
 		// sanity check broker communications early
 		_, err = c.GetMetadata(nil, true, SessionTimeoutDefault)
@@ -109,6 +124,15 @@ func (sc *StreamConfig) NewConsumer(km *kafka.ConfigMap) (*kafka.Consumer, error
 	}
 }
 
+// subscription returns the topic NewConsumer subscribes to, with Glob the regex
+// `^<prefix>.<topic>.*` matching every topic starting with the full topic
+func (sc StreamConfig) subscription() string {
+	if sc.Glob {
+		return fmt.Sprintf("^%s.*", sc.FullTopic(""))
+	}
+	return sc.FullTopic("")
+}
+
 // producerDefaults returns a *kafka.ConfigMap with sane defaults
 func (sc StreamConfig) ProducerDefaults() *kafka.ConfigMap {
 	if sc.Codec == "" {
@@ -175,6 +199,7 @@ func (sc *StreamConfig) Close() error {
 	return nil
 }
 
+// Produce produces value to topic. Traces are not propagated, see ProduceContext.
 func (sc *StreamConfig) Produce(topic *string, value []byte) error {
 	if sc.producer == nil {
 		panic("internal failure, no producer set")
@@ -186,6 +211,47 @@ func (sc *StreamConfig) Produce(topic *string, value []byte) error {
 		Value: value}, nil)
 }
 
+// ProduceContext is Produce with the span in ctx (if any) propagated through the message headers.
+// A "produce" span is recorded, and when DeliveryReports are enabled so is a "deliver" span which
+// ends once the delivery report arrives.
+func (sc *StreamConfig) ProduceContext(ctx context.Context, topic *string, value []byte) error {
+	return sc.produceContext(ctx, topic, func(SpanContext) ([]byte, error) { return value, nil })
+}
+
+// produceContext is ProduceContext with the value built once the produce span is started
+func (sc *StreamConfig) produceContext(ctx context.Context, topic *string, value func(SpanContext) ([]byte, error)) error {
+	if sc.producer == nil {
+		panic("internal failure, no producer set")
+	}
+
+	ctx, span := sc.Tracer().Start(ctx, "produce")
+	span.SetAttribute("topic", *topic)
+
+	b, err := value(span.Context())
+	if err != nil {
+		span.End(err)
+		return err
+	}
+	m := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: topic,
+			Partition: kafka.PartitionAny},
+		Value: b}
+	InjectTrace(m, span.Context())
+
+	var deliver Span
+	if sc.DeliveryReports {
+		_, deliver = sc.Tracer().Start(ctx, "deliver")
+		m.Opaque = deliver
+	}
+
+	err = sc.producer.Produce(m, nil)
+	if err != nil && deliver != nil {
+		deliver.End(err)
+	}
+	span.End(err)
+	return err
+}
+
 func (sc StreamConfig) Flush(ms int) int {
 	if sc.producer == nil {
 		panic("internal failure, no producer set")
@@ -215,6 +281,10 @@ func (sc StreamConfig) deliverReports() error {
 		for e := range sc.producer.Events() {
 			switch ev := e.(type) {
 			case *kafka.Message:
+				if span, ok := ev.Opaque.(Span); ok {
+					setMessageAttributes(span, ev)
+					span.End(ev.TopicPartition.Error)
+				}
 				if ev.TopicPartition.Error != nil && sc.deliveryError != nil {
 					sc.deliveryError(ev)
 				}
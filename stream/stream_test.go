@@ -60,6 +60,13 @@ func (s *streamSuite) TestEnvironment() {
 	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 }
 
+func (s *streamSuite) TestSubscription() {
+	sc := StreamConfig{Prefix: "atsu", Topic: "health"}
+	s.Equal("atsu.health", sc.subscription())
+	sc.Glob = true
+	s.Equal("^atsu.health.*", sc.subscription())
+}
+
 func (s *streamSuite) TestJsonMarshal() {
 	data := `{"brokers":"testbroker","prefix":"test.prefix","topic":"sometopic","messages":123,"bytes":100,"offset":"sdfasdf1","group_id":"id123","glob":true,"reports":true,"codec":"codectest"}`
 
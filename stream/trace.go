@@ -0,0 +1,258 @@
+package stream
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// TraceParentHeader is the message header carrying a W3C traceparent style span context
+const TraceParentHeader = "traceparent"
+
+const traceParentVersion = "00"
+
+// SpanContext identifies a span within a trace, it is what propagates across process boundaries
+type SpanContext struct {
+	TraceId [16]byte
+	SpanId  [8]byte
+	Flags   byte // 0x01 = sampled
+}
+
+// IsValid returns false for a zero trace or span id, as required by the traceparent spec
+func (s SpanContext) IsValid() bool {
+	return s.TraceId != [16]byte{} && s.SpanId != [8]byte{}
+}
+
+// TraceIdString returns the hex encoded trace id
+func (s SpanContext) TraceIdString() string {
+	return hex.EncodeToString(s.TraceId[:])
+}
+
+// String returns the traceparent encoding, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func (s SpanContext) String() string {
+	return fmt.Sprintf("%s-%s-%s-%02x", traceParentVersion, s.TraceIdString(), hex.EncodeToString(s.SpanId[:]), s.Flags)
+}
+
+// ParseTraceParent parses a traceparent encoded SpanContext
+func ParseTraceParent(tp string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(tp), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, fmt.Errorf("invalid traceparent: %q", tp)
+	}
+	// future versions may append fields, version 00 may not
+	if parts[0] == traceParentVersion && len(parts) != 4 {
+		return sc, fmt.Errorf("invalid traceparent: %q", tp)
+	}
+
+	if err := decodeHex(sc.TraceId[:], parts[1]); err != nil {
+		return sc, fmt.Errorf("invalid traceparent trace id: %v", err)
+	}
+	if err := decodeHex(sc.SpanId[:], parts[2]); err != nil {
+		return sc, fmt.Errorf("invalid traceparent span id: %v", err)
+	}
+	var flags [1]byte
+	if err := decodeHex(flags[:], parts[3]); err != nil {
+		return sc, fmt.Errorf("invalid traceparent flags: %v", err)
+	}
+	sc.Flags = flags[0]
+
+	if !sc.IsValid() {
+		return SpanContext{}, errors.New("invalid traceparent: zero trace or span id")
+	}
+	return sc, nil
+}
+
+// decodeHex decodes lower case hex into dst, which s must exactly fill
+func decodeHex(dst []byte, s string) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return fmt.Errorf("expected %d lower case hex digits, got %q", hex.EncodedLen(len(dst)), s)
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+// NewSpanContext returns a child of parent, or the root of a new sampled trace if parent is not valid
+func NewSpanContext(parent SpanContext) SpanContext {
+	sc := parent
+	if !parent.IsValid() {
+		_, _ = rand.Read(sc.TraceId[:])
+		sc.Flags = 0x01
+	}
+	_, _ = rand.Read(sc.SpanId[:])
+	return sc
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying sc
+func ContextWithSpan(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanFromContext returns the SpanContext carried by ctx, if any
+func SpanFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// InjectTrace sets (or replaces) the traceparent header of m
+func InjectTrace(m *kafka.Message, sc SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	value := []byte(sc.String())
+	for i, h := range m.Headers {
+		if h.Key == TraceParentHeader {
+			m.Headers[i].Value = value
+			return
+		}
+	}
+	m.Headers = append(m.Headers, kafka.Header{Key: TraceParentHeader, Value: value})
+}
+
+// ExtractTrace returns the SpanContext carried in the traceparent header of m, if any
+func ExtractTrace(m *kafka.Message) (SpanContext, bool) {
+	for _, h := range m.Headers {
+		if h.Key == TraceParentHeader {
+			sc, err := ParseTraceParent(string(h.Value))
+			return sc, err == nil
+		}
+	}
+	return SpanContext{}, false
+}
+
+// Tracer records spans, see NoopTracer and RecordingTracer
+type Tracer interface {
+	// Start begins a span named name as a child of the span in ctx (if any),
+	// and returns a ctx carrying the new span
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single traced operation
+type Span interface {
+	Context() SpanContext
+	SetAttribute(key string, value interface{})
+	End(err error) // err may be nil
+}
+
+// NoopTracer records nothing, but still propagates span contexts so traces are not broken
+type NoopTracer struct{}
+
+var _ Tracer = NoopTracer{}
+
+func (NoopTracer) Start(ctx context.Context, _ string) (context.Context, Span) {
+	parent, _ := SpanFromContext(ctx)
+	span := noopSpan(NewSpanContext(parent))
+	return ContextWithSpan(ctx, span.Context()), span
+}
+
+type noopSpan SpanContext
+
+func (s noopSpan) Context() SpanContext               { return SpanContext(s) }
+func (noopSpan) SetAttribute(_ string, _ interface{}) {}
+func (noopSpan) End(_ error)                          {}
+
+// RecordedSpan is a span as captured by RecordingTracer
+type RecordedSpan struct {
+	Name       string
+	Context    SpanContext
+	Parent     SpanContext // zero if the span is a root
+	Attributes map[string]interface{}
+	Start      time.Time
+	End        time.Time // zero until ended
+	Err        error
+}
+
+// RecordingTracer keeps every span in memory, it is intended for tests
+type RecordingTracer struct {
+	mux   sync.Mutex
+	spans []*RecordedSpan
+}
+
+var _ Tracer = &RecordingTracer{}
+
+func NewRecordingTracer() *RecordingTracer {
+	return &RecordingTracer{}
+}
+
+func (t *RecordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := SpanFromContext(ctx)
+	rs := &RecordedSpan{
+		Name:       name,
+		Context:    NewSpanContext(parent),
+		Parent:     parent,
+		Attributes: make(map[string]interface{}),
+		Start:      time.Now(),
+	}
+
+	t.mux.Lock()
+	t.spans = append(t.spans, rs)
+	t.mux.Unlock()
+
+	return ContextWithSpan(ctx, rs.Context), &recordingSpan{tracer: t, span: rs}
+}
+
+// Spans returns a copy of all spans started so far, in start order
+func (t *RecordingTracer) Spans() []RecordedSpan {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	out := make([]RecordedSpan, 0, len(t.spans))
+	for _, rs := range t.spans {
+		cp := *rs
+		cp.Attributes = make(map[string]interface{}, len(rs.Attributes))
+		for k, v := range rs.Attributes {
+			cp.Attributes[k] = v
+		}
+		out = append(out, cp)
+	}
+	return out
+}
+
+// Reset discards all recorded spans
+func (t *RecordingTracer) Reset() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.spans = nil
+}
+
+type recordingSpan struct {
+	tracer *RecordingTracer
+	span   *RecordedSpan
+}
+
+func (s *recordingSpan) Context() SpanContext {
+	return s.span.Context
+}
+
+func (s *recordingSpan) SetAttribute(key string, value interface{}) {
+	s.tracer.mux.Lock()
+	defer s.tracer.mux.Unlock()
+	s.span.Attributes[key] = value
+}
+
+func (s *recordingSpan) End(err error) {
+	s.tracer.mux.Lock()
+	defer s.tracer.mux.Unlock()
+	if s.span.End.IsZero() {
+		s.span.End = time.Now()
+		s.span.Err = err
+	}
+}
+
+func setMessageAttributes(span Span, m *kafka.Message) {
+	if m.TopicPartition.Topic != nil {
+		span.SetAttribute("topic", *m.TopicPartition.Topic)
+	}
+	span.SetAttribute("partition", m.TopicPartition.Partition)
+	if m.TopicPartition.Offset >= 0 {
+		span.SetAttribute("offset", int64(m.TopicPartition.Offset))
+	}
+}
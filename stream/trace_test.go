@@ -0,0 +1,233 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	tp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceParent(tp)
+	assert.NoError(t, err)
+	assert.True(t, sc.IsValid())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceIdString())
+	assert.Equal(t, byte(0x01), sc.Flags)
+	assert.Equal(t, tp, sc.String())
+
+	// future versions may carry extra fields
+	_, err = ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+	assert.NoError(t, err)
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",
+	} {
+		_, err := ParseTraceParent(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestNewSpanContext(t *testing.T) {
+	root := NewSpanContext(SpanContext{})
+	assert.True(t, root.IsValid())
+
+	child := NewSpanContext(root)
+	assert.Equal(t, root.TraceId, child.TraceId)
+	assert.NotEqual(t, root.SpanId, child.SpanId)
+	assert.Equal(t, root.Flags, child.Flags)
+}
+
+func TestInjectExtractTrace(t *testing.T) {
+	m := &kafka.Message{Headers: []kafka.Header{{Key: "other", Value: []byte("x")}}}
+	_, ok := ExtractTrace(m)
+	assert.False(t, ok)
+
+	first := NewSpanContext(SpanContext{})
+	InjectTrace(m, first)
+	got, ok := ExtractTrace(m)
+	assert.True(t, ok)
+	assert.Equal(t, first, got)
+
+	// injecting again replaces rather than appends
+	second := NewSpanContext(first)
+	InjectTrace(m, second)
+	assert.Len(t, m.Headers, 2)
+	got, _ = ExtractTrace(m)
+	assert.Equal(t, second, got)
+
+	// an invalid context is never injected
+	m = &kafka.Message{}
+	InjectTrace(m, SpanContext{})
+	assert.Empty(t, m.Headers)
+}
+
+func TestNoopTracerPropagates(t *testing.T) {
+	parent := NewSpanContext(SpanContext{})
+	ctx, span := NoopTracer{}.Start(ContextWithSpan(context.Background(), parent), "op")
+	span.SetAttribute("k", "v")
+	span.End(nil)
+
+	got, ok := SpanFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, span.Context(), got)
+	assert.Equal(t, parent.TraceId, got.TraceId)
+	assert.NotEqual(t, parent.SpanId, got.SpanId)
+}
+
+func TestRecordingTracer(t *testing.T) {
+	tr := NewRecordingTracer()
+	ctx, root := tr.Start(context.Background(), "root")
+	_, child := tr.Start(ctx, "child")
+	child.SetAttribute("n", 1)
+	child.End(errors.New("boom"))
+	child.End(nil) // ending twice keeps the first result
+
+	spans := tr.Spans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "root", spans[0].Name)
+	assert.False(t, spans[0].Parent.IsValid())
+	assert.True(t, spans[0].End.IsZero())
+
+	assert.Equal(t, "child", spans[1].Name)
+	assert.Equal(t, root.Context(), spans[1].Parent)
+	assert.Equal(t, root.Context().TraceId, spans[1].Context.TraceId)
+	assert.Equal(t, 1, spans[1].Attributes["n"])
+	assert.EqualError(t, spans[1].Err, "boom")
+	assert.False(t, spans[1].End.IsZero())
+
+	tr.Reset()
+	assert.Empty(t, tr.Spans())
+}
+
+// tracedConsumer is a minimal StreamConsumer implementing TracedStreamConsumer
+type tracedConsumer struct {
+	ctx      context.Context
+	messages int
+}
+
+func (c *tracedConsumer) Start(*StreamConfig, interface{}) error { return nil }
+func (c *tracedConsumer) Message(*kafka.Message) error {
+	c.messages++
+	return nil
+}
+func (c *tracedConsumer) MessageContext(ctx context.Context, _ *kafka.Message) error {
+	c.ctx = ctx
+	return errors.New("stop")
+}
+func (c *tracedConsumer) Interval(time.Time) error     { return nil }
+func (c *tracedConsumer) Timeout(time.Time, bool) bool { return false }
+func (c *tracedConsumer) Error(kafka.Error) bool       { return false }
+func (c *tracedConsumer) Process() (bool, error)       { return false, nil }
+func (c *tracedConsumer) Finish() error                { return nil }
+func (c *tracedConsumer) DoneCh() <-chan bool          { return nil }
+
+func TestConsumeMessageTrace(t *testing.T) {
+	tr := NewRecordingTracer()
+	sc := &StreamConfig{}
+	sc.SetTracer(tr)
+
+	topic := "atsu.test"
+	parent := NewSpanContext(SpanContext{})
+	m := &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 1, Offset: 9}}
+	InjectTrace(m, parent)
+
+	c := &tracedConsumer{}
+	assert.EqualError(t, sc.consumeMessage(c, m), "stop")
+	assert.Zero(t, c.messages) // MessageContext is preferred
+
+	spans := tr.Spans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "consume", spans[0].Name)
+	assert.Equal(t, parent, spans[0].Parent)
+	assert.Equal(t, topic, spans[0].Attributes["topic"])
+	assert.Equal(t, int32(1), spans[0].Attributes["partition"])
+	assert.Equal(t, int64(9), spans[0].Attributes["offset"])
+	assert.EqualError(t, spans[0].Err, "stop")
+
+	got, ok := SpanFromContext(c.ctx)
+	assert.True(t, ok)
+	assert.Equal(t, spans[0].Context, got)
+}
+
+func TestProduceContextTrace(t *testing.T) {
+	p, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": "0.0.0.0:9092"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	tr := NewRecordingTracer()
+	sc := &StreamConfig{DeliveryReports: true, producer: p}
+	sc.SetTracer(tr)
+
+	parent := NewSpanContext(SpanContext{})
+	topic := "atsu.test"
+	assert.NoError(t, sc.ProduceContext(ContextWithSpan(context.Background(), parent), &topic, []byte("v")))
+
+	spans := tr.Spans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "produce", spans[0].Name)
+	assert.Equal(t, parent, spans[0].Parent)
+	assert.False(t, spans[0].End.IsZero())
+	assert.Equal(t, "deliver", spans[1].Name)
+	assert.Equal(t, spans[0].Context, spans[1].Parent)
+	assert.True(t, spans[1].End.IsZero()) // no broker, no delivery report
+}
+
+func TestProduceEnvelopeTrace(t *testing.T) {
+	p, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": "0.0.0.0:9092"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	tr := NewRecordingTracer()
+	sc := &StreamConfig{producer: p}
+	sc.SetTracer(tr)
+	topic := "atsu.test"
+	envelope := func(traceId string) *Envelope {
+		e, err := NewEnvelope("test", "health", map[string]string{"state": "green"})
+		assert.NoError(t, err)
+		e.TraceId = traceId
+		return e
+	}
+
+	// the trace in ctx wins
+	parent := NewSpanContext(SpanContext{})
+	e := envelope("4bf92f3577b34da6a3ce929d0e0e4736")
+	assert.NoError(t, sc.ProduceEnvelopeContext(ContextWithSpan(context.Background(), parent), &topic, e))
+	assert.Equal(t, parent.TraceIdString(), e.TraceId)
+	assert.Equal(t, parent, tr.Spans()[0].Parent)
+
+	// then the envelope trace
+	e = envelope("4bf92f3577b34da6a3ce929d0e0e4736")
+	assert.NoError(t, sc.ProduceEnvelope(&topic, e))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", e.TraceId)
+	assert.Equal(t, e.TraceId, tr.Spans()[1].Context.TraceIdString())
+
+	// or a new one
+	for _, traceId := range []string{"", "nope"} {
+		e = envelope(traceId)
+		assert.NoError(t, sc.ProduceEnvelope(&topic, e))
+		spans := tr.Spans()
+		assert.Equal(t, spans[len(spans)-1].Context.TraceIdString(), e.TraceId)
+		assert.False(t, spans[len(spans)-1].Parent.IsValid())
+	}
+
+	e = envelope("")
+	e.Source = ""
+	assert.Error(t, sc.ProduceEnvelope(&topic, e))
+	assert.Len(t, tr.Spans(), 4)
+}